@@ -0,0 +1,394 @@
+package menu
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/cybozu-go/placemat"
+)
+
+const (
+	backendBIRD  = "bird"
+	backendFRR   = "frr"
+	backendGoBGP = "gobgp"
+
+	defaultBFDMultiplier = 5
+	maxFRRPaths          = 64
+)
+
+// RouterBackend is a routing software running on core, spine and ToR routers
+type RouterBackend interface {
+	// Containers returns the app specs to run the routing software
+	Containers() []*placemat.PodAppSpec
+
+	// Volumes returns the volumes of the pod.  folder is the name of the data folder.
+	Volumes(folder string) []*placemat.PodVolumeSpec
+
+	// ConfigFiles returns the files of the router in its data folder.
+	// role is one of "core", "spine", "rack-tor" and "rack-stor".
+	ConfigFiles(role, router string) []RouterConfigFile
+}
+
+// RouterConfigFile is a file in the data folder of a router
+type RouterConfigFile struct {
+	// Name is the name of the file in the data folder
+	Name string
+	// File is the name of the generated file
+	File string
+	// Template is the path of the embedded template to generate File
+	Template string
+}
+
+// RouterConfig is a configuration file of a router to be generated from Template with Args.
+// Args is the *RouterModel of the router.
+// Mode is the permission of File, or 0 to use that of Template.
+type RouterConfig struct {
+	Template string
+	File     string
+	Args     interface{}
+	Mode     os.FileMode
+}
+
+var routerBackends = map[string]RouterBackend{
+	backendBIRD:  birdBackend{},
+	backendFRR:   frrBackend{},
+	backendGoBGP: gobgpBackend{},
+}
+
+// configAndRunVolumes returns the volumes for the configuration and runtime files
+func configAndRunVolumes(folder string) []*placemat.PodVolumeSpec {
+	return []*placemat.PodVolumeSpec{
+		{
+			Name:     "config",
+			Kind:     "host",
+			Folder:   folder,
+			ReadOnly: true,
+		},
+		{
+			Name: "run",
+			Kind: "empty",
+		},
+	}
+}
+
+type birdBackend struct{}
+
+func (birdBackend) Containers() []*placemat.PodAppSpec {
+	return []*placemat.PodAppSpec{&birdContainer}
+}
+
+func (birdBackend) Volumes(folder string) []*placemat.PodVolumeSpec {
+	return configAndRunVolumes(folder)
+}
+
+func (birdBackend) ConfigFiles(role, router string) []RouterConfigFile {
+	return []RouterConfigFile{
+		{
+			Name:     "bird.conf",
+			File:     fmt.Sprintf("bird_%s.conf", router),
+			Template: fmt.Sprintf("/templates/bird_%s.conf", role),
+		},
+	}
+}
+
+var frrContainer = placemat.PodAppSpec{
+	Name:  "frr",
+	Image: dockerImageFRR,
+	Mount: []placemat.PodAppMountSpec{
+		{
+			Volume: "config",
+			Target: "/etc/frr",
+		},
+		{
+			Volume: "run",
+			Target: "/var/run/frr",
+		},
+	},
+	CapsRetain: []string{
+		"CAP_NET_ADMIN",
+		"CAP_NET_BIND_SERVICE",
+		"CAP_NET_RAW",
+		"CAP_SYS_ADMIN",
+	},
+}
+
+type frrBackend struct{}
+
+func (frrBackend) Containers() []*placemat.PodAppSpec {
+	return []*placemat.PodAppSpec{&frrContainer}
+}
+
+func (frrBackend) Volumes(folder string) []*placemat.PodVolumeSpec {
+	return configAndRunVolumes(folder)
+}
+
+func (frrBackend) ConfigFiles(role, router string) []RouterConfigFile {
+	return []RouterConfigFile{
+		{
+			Name:     "frr.conf",
+			File:     fmt.Sprintf("frr_%s.conf", router),
+			Template: fmt.Sprintf("/templates/frr_%s.conf", role),
+		},
+		{
+			Name:     "daemons",
+			File:     "frr_daemons",
+			Template: "/templates/frr_daemons",
+		},
+	}
+}
+
+// gobgpContainer runs gobgpd.  Routes can be injected through the gRPC API.
+// gobgpd installs the best paths into the kernel through zebra running in
+// zebraContainer, and announces the static routes configured in zebra.
+var gobgpContainer = placemat.PodAppSpec{
+	Name:           "gobgp",
+	Image:          dockerImageGoBGP,
+	ReadOnlyRootfs: true,
+	Exec:           "/usr/local/bin/gobgpd",
+	Args: []string{
+		"-f", "/etc/gobgp/gobgpd.toml",
+		"-t", "toml",
+		"--api-hosts=0.0.0.0:50051",
+	},
+	Mount: []placemat.PodAppMountSpec{
+		{
+			Volume: "config",
+			Target: "/etc/gobgp",
+		},
+		{
+			Volume: "run",
+			Target: "/var/run/frr",
+		},
+	},
+	CapsRetain: []string{
+		"CAP_NET_BIND_SERVICE",
+	},
+}
+
+// zebraContainer runs zebra and staticd of FRRouting without bgpd for gobgpd
+var zebraContainer = placemat.PodAppSpec{
+	Name:  "zebra",
+	Image: dockerImageFRR,
+	Mount: []placemat.PodAppMountSpec{
+		{
+			Volume: "config",
+			Target: "/etc/frr",
+		},
+		{
+			Volume: "run",
+			Target: "/var/run/frr",
+		},
+	},
+	CapsRetain: []string{
+		"CAP_NET_ADMIN",
+		"CAP_NET_RAW",
+		"CAP_SYS_ADMIN",
+	},
+}
+
+type gobgpBackend struct{}
+
+func (gobgpBackend) Containers() []*placemat.PodAppSpec {
+	return []*placemat.PodAppSpec{&gobgpContainer, &zebraContainer}
+}
+
+func (gobgpBackend) Volumes(folder string) []*placemat.PodVolumeSpec {
+	return configAndRunVolumes(folder)
+}
+
+func (gobgpBackend) ConfigFiles(role, router string) []RouterConfigFile {
+	return []RouterConfigFile{
+		{
+			Name:     "gobgpd.toml",
+			File:     fmt.Sprintf("gobgp_%s.toml", router),
+			Template: "/templates/gobgpd.toml",
+		},
+		{
+			Name:     "frr.conf",
+			File:     fmt.Sprintf("zebra_%s.conf", router),
+			Template: "/templates/zebra.conf",
+		},
+		{
+			Name:     "daemons",
+			File:     "zebra_daemons",
+			Template: "/templates/zebra_daemons",
+		},
+	}
+}
+
+func validateRouterBackends(network *NetworkMenu) error {
+	if network.RouterBackends.ToR == backendGoBGP {
+		return errors.New("gobgp backend is available only for core and spine")
+	}
+	for _, name := range []string{network.RouterBackends.Core, network.RouterBackends.Spine, network.RouterBackends.ToR} {
+		if name == backendBIRD {
+			continue
+		}
+		if network.RoutingPolicy.Filter != nil || network.RoutingPolicy.Communities != nil {
+			return fmt.Errorf("%s backend does not support filter and communities in routing-policy", name)
+		}
+	}
+	return nil
+}
+
+// CoreBFD returns true if the core router supports BFD
+func (b RouterBackendsMenu) CoreBFD() bool {
+	return b.Core != backendGoBGP
+}
+
+// SpineBFD returns true if spine switches support BFD
+func (b RouterBackendsMenu) SpineBFD() bool {
+	return b.Spine != backendGoBGP
+}
+
+func (t TemplateArgs) backend(name string) RouterBackend {
+	b, ok := routerBackends[name]
+	if !ok {
+		return routerBackends[backendBIRD]
+	}
+	return b
+}
+
+func (t TemplateArgs) coreBackend() RouterBackend {
+	return t.backend(t.Network.RouterBackends.Core)
+}
+
+func (t TemplateArgs) spineBackend() RouterBackend {
+	return t.backend(t.Network.RouterBackends.Spine)
+}
+
+func (t TemplateArgs) torBackend() RouterBackend {
+	return t.backend(t.Network.RouterBackends.ToR)
+}
+
+// RouterConfigs returns the configuration files of core, spine, ToR and storage ToR
+// routers, and external peers.  The files are not readable by others if BGP sessions
+// are authenticated, as they contain the passwords.
+func RouterConfigs(ta *TemplateArgs) ([]RouterConfig, error) {
+	var configs []RouterConfig
+	add := func(b RouterBackend, role string, r *RouterModel) error {
+		err := validateRouterModel(r)
+		if err != nil {
+			return err
+		}
+		for _, f := range b.ConfigFiles(role, r.Name) {
+			configs = append(configs, RouterConfig{Template: f.Template, File: f.File, Args: r, Mode: ta.RouterConfigMode()})
+		}
+		return nil
+	}
+
+	err := add(ta.coreBackend(), "core", ta.CoreRouter())
+	if err != nil {
+		return nil, err
+	}
+	for spineIdx := range ta.Spines {
+		err = add(ta.spineBackend(), "spine", ta.SpineRouter(spineIdx))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for rackIdx, rack := range ta.Racks {
+		err = add(ta.torBackend(), "rack-tor", ta.ToRRouter(rackIdx, 1))
+		if err != nil {
+			return nil, err
+		}
+		err = add(ta.torBackend(), "rack-tor", ta.ToRRouter(rackIdx, 2))
+		if err != nil {
+			return nil, err
+		}
+		if rack.StorageToR != nil {
+			err = add(ta.torBackend(), "rack-stor", ta.StorageToRRouter(rackIdx))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for peerIdx := range ta.ExternalPeers {
+		err = add(routerBackends[backendBIRD], "external-peer", ta.ExternalPeerRouter(peerIdx))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return configs, nil
+}
+
+func routerDataFolder(b RouterBackend, role, router string) *placemat.DataFolderSpec {
+	folder := &placemat.DataFolderSpec{
+		Kind: "DataFolder",
+		Name: fmt.Sprintf("%s-data", router),
+	}
+	for _, f := range b.ConfigFiles(role, router) {
+		folder.Files = append(folder.Files, placemat.DataFolderFileSpec{
+			Name: f.Name,
+			File: f.File,
+		})
+	}
+	return folder
+}
+
+// DetectMultiplier returns the BFD detection multiplier
+func (p RoutingPolicy) DetectMultiplier() int {
+	if p.BFDMultiplier == 0 {
+		return defaultBFDMultiplier
+	}
+	return p.BFDMultiplier
+}
+
+// MaximumPaths returns the maximum number of paths for ECMP
+func (p RoutingPolicy) MaximumPaths() int {
+	if p.ECMPLimit == 0 {
+		return maxFRRPaths
+	}
+	return p.ECMPLimit
+}
+
+// timers returns the keepalive and hold time.  If only one of them is
+// specified, the other is derived from it.
+func (p RoutingPolicy) timers() (keepalive, hold int) {
+	keepalive, hold = p.KeepaliveTime, p.HoldTime
+	switch {
+	case keepalive == 0 && hold == 0:
+	case keepalive == 0:
+		keepalive = hold / 3
+	case hold == 0:
+		hold = keepalive * 3
+	}
+	return keepalive, hold
+}
+
+// BGPTimers returns the keepalive and hold time in FRR syntax, or an empty
+// string if they are not specified
+func (p RoutingPolicy) BGPTimers() string {
+	keepalive, hold := p.timers()
+	if keepalive == 0 && hold == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d %d", keepalive, hold)
+}
+
+// KeepaliveInterval returns the keepalive time, or 0 if timers are not specified
+func (p RoutingPolicy) KeepaliveInterval() int {
+	keepalive, _ := p.timers()
+	return keepalive
+}
+
+// HoldTimeInterval returns the hold time, or 0 if timers are not specified
+func (p RoutingPolicy) HoldTimeInterval() int {
+	_, hold := p.timers()
+	return hold
+}
+
+// NodeNetwork returns the network of the node interface
+func (t ToR) NodeNetwork() *net.IPNet {
+	return &net.IPNet{IP: t.NodeAddress.IP.Mask(t.NodeAddress.Mask), Mask: t.NodeAddress.Mask}
+}
+
+// BondNetwork returns the network of the bond interface, or nil
+func (t ToR) BondNetwork() *net.IPNet {
+	if t.BondAddress == nil {
+		return nil
+	}
+	return &net.IPNet{IP: t.BondAddress.IP.Mask(t.BondAddress.Mask), Mask: t.BondAddress.Mask}
+}
@@ -0,0 +1,217 @@
+package menu
+
+import (
+	"testing"
+)
+
+func TestRouterBackends(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, Boot: 1}})
+	m.Network.RouterBackends = RouterBackendsMenu{Core: backendBIRD, Spine: backendFRR, ToR: backendBIRD}
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configs, err := RouterConfigs(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, rc := range configs {
+		files = append(files, rc.File)
+	}
+	expected := []string{
+		"bird_core.conf",
+		"frr_spine1.conf", "frr_daemons",
+		"frr_spine2.conf", "frr_daemons",
+		"bird_rack0-tor1.conf",
+		"bird_rack0-tor2.conf",
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("unexpected router configs: %v", files)
+	}
+	for i := range expected {
+		if files[i] != expected[i] {
+			t.Errorf("unexpected router configs: %v", files)
+			break
+		}
+	}
+	if r, ok := configs[3].Args.(*RouterModel); !ok || r.Name != "spine2" {
+		t.Errorf("unexpected args of spine2: %v", configs[3].Args)
+	}
+
+	c, err := generateCluster(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range c.pods {
+		switch p.Name {
+		case "spine1":
+			if p.Apps[0].Name != "frr" {
+				t.Errorf("spine1 must run frr: %s", p.Apps[0].Name)
+			}
+		case "core", "rack0-tor1":
+			if p.Apps[0].Name != "bird" {
+				t.Errorf("%s must run bird: %s", p.Name, p.Apps[0].Name)
+			}
+		}
+	}
+	for _, f := range c.dataFolders {
+		if f.Name == "spine1-data" && (len(f.Files) != 2 || f.Files[1].Name != "daemons") {
+			t.Errorf("unexpected data folder of spine1: %v", f.Files)
+		}
+	}
+
+	m.Network.RoutingPolicy.Filter = &RouteFilterMenu{}
+	err = validateRouterBackends(m.Network)
+	if err == nil {
+		t.Error("frr backend must not be used with filter")
+	}
+}
+
+func TestStorageToRBackend(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(1, []RackMenu{{SS: 1, Boot: 1}, {SS: 1, Boot: 1}})
+	m.Network.Storage = mustParseCIDR("10.70.0.0/20")
+	m.Network.StorageRangeSize = 6
+	m.Network.StorageBackbone = mustParseCIDR("10.70.255.0/24")
+	m.Network.RouterBackends = RouterBackendsMenu{Core: backendBIRD, Spine: backendBIRD, ToR: backendFRR}
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configs, err := RouterConfigs(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, rc := range configs {
+		if rc.File == "bird_rack0-stor.conf" {
+			t.Error("storage ToR must follow the tor backend")
+		}
+		if rc.File == "frr_rack0-stor.conf" && rc.Template == "/templates/frr_rack-stor.conf" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("frr_rack0-stor.conf is not generated")
+	}
+
+	c, err := generateCluster(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range c.pods {
+		if p.Name == "rack0-stor" && p.Apps[0].Name != "frr" {
+			t.Errorf("rack0-stor must run frr: %s", p.Apps[0].Name)
+		}
+	}
+	for _, f := range c.dataFolders {
+		if f.Name == "rack0-stor-data" && (len(f.Files) != 2 || f.Files[0].File != "frr_rack0-stor.conf") {
+			t.Errorf("unexpected data folder of rack0-stor: %v", f.Files)
+		}
+	}
+}
+
+func TestGoBGPBackend(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, Boot: 1}})
+	m.Network.RouterBackends = RouterBackendsMenu{Core: backendGoBGP, Spine: backendGoBGP, ToR: backendBIRD}
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configs, err := RouterConfigs(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, rc := range configs {
+		files = append(files, rc.File)
+	}
+	expected := []string{
+		"gobgp_core.toml", "zebra_core.conf", "zebra_daemons",
+		"gobgp_spine1.toml", "zebra_spine1.conf", "zebra_daemons",
+		"gobgp_spine2.toml", "zebra_spine2.conf", "zebra_daemons",
+		"bird_rack0-tor1.conf",
+		"bird_rack0-tor2.conf",
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("unexpected router configs: %v", files)
+	}
+	for i := range expected {
+		if files[i] != expected[i] {
+			t.Errorf("unexpected router configs: %v", files)
+			break
+		}
+	}
+
+	c, err := generateCluster(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range c.pods {
+		switch p.Name {
+		case "core", "spine1":
+			if p.Apps[0].Name != "gobgp" || p.Apps[1].Name != "zebra" {
+				t.Errorf("%s must run gobgp with zebra: %s %s", p.Name, p.Apps[0].Name, p.Apps[1].Name)
+			}
+			if len(p.Volumes) != 2 || p.Volumes[0].Folder != p.Name+"-data" {
+				t.Errorf("unexpected volumes of %s: %v", p.Name, p.Volumes)
+			}
+		case "rack0-tor1":
+			if len(p.Volumes) != 2 {
+				t.Errorf("unexpected volumes of rack0-tor1: %v", p.Volumes)
+			}
+		}
+	}
+
+	if ta.Network.RouterBackends.CoreBFD() || ta.Network.RouterBackends.SpineBFD() {
+		t.Error("gobgp must not run BFD")
+	}
+
+	spine := ta.SpineRouter(0)
+	if spine.Group(GroupToR).ExportStatics || !spine.Group(GroupCore).ExportStatics {
+		t.Error("exposed ranges must be announced only to the core router")
+	}
+	core := ta.CoreRouter()
+	if !core.Group(GroupSpine).ExportStatics || core.Group(GroupExternal).ExportStatics {
+		t.Error("the default route must be announced only to spine switches")
+	}
+
+	m.Network.RouterBackends.ToR = backendGoBGP
+	err = validateRouterBackends(m.Network)
+	if err == nil {
+		t.Error("gobgp backend must not be used for ToR switches")
+	}
+}
+
+func TestBGPTimers(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		policy    RoutingPolicy
+		expected  string
+		keepalive int
+		hold      int
+	}{
+		{RoutingPolicy{}, "", 0, 0},
+		{RoutingPolicy{HoldTime: 9}, "3 9", 3, 9},
+		{RoutingPolicy{KeepaliveTime: 10}, "10 30", 10, 30},
+		{RoutingPolicy{HoldTime: 90, KeepaliveTime: 20}, "20 90", 20, 90},
+	}
+	for _, c := range cases {
+		if actual := c.policy.BGPTimers(); actual != c.expected {
+			t.Errorf("%v: %s != %s", c.policy, actual, c.expected)
+		}
+		if c.policy.KeepaliveInterval() != c.keepalive || c.policy.HoldTimeInterval() != c.hold {
+			t.Errorf("%v: unexpected timers: %d %d", c.policy, c.policy.KeepaliveInterval(), c.policy.HoldTimeInterval())
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package menu
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// maxBGPPasswordLength is the maximum length of TCP-MD5 keys in Linux
+const maxBGPPasswordLength = 80
+
+// bgpSecret is an entry of a BGP secrets file and the secrets manifest
+type bgpSecret struct {
+	Peers    []string `yaml:"peers"`
+	Password string   `yaml:"password"`
+}
+
+// bgpSessionKey returns a key identifying the BGP session between a and b.
+// The key does not depend on the order of a and b.
+func bgpSessionKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + " " + b
+}
+
+// deriveBGPPassword derives the password of the BGP session between a and b from seed
+func deriveBGPPassword(seed, a, b string) string {
+	mac := hmac.New(sha256.New, []byte(seed))
+	mac.Write([]byte(bgpSessionKey(a, b)))
+	return fmt.Sprintf("%x", mac.Sum(nil)[:16])
+}
+
+func readBGPSecrets(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []bgpSecret
+	err = yaml.Unmarshal(data, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := make(map[string]string)
+	for _, e := range entries {
+		if len(e.Peers) != 2 {
+			return nil, fmt.Errorf("BGP secret must have exactly 2 peers: %v", e.Peers)
+		}
+		err = validateBGPPassword(e.Password)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BGP secret for %v: %v", e.Peers, err)
+		}
+		key := bgpSessionKey(e.Peers[0], e.Peers[1])
+		if _, ok := secrets[key]; ok {
+			return nil, fmt.Errorf("duplicate BGP secret for %v", e.Peers)
+		}
+		secrets[key] = e.Password
+	}
+	return secrets, nil
+}
+
+func validateBGPPassword(password string) error {
+	if len(password) == 0 {
+		return errors.New("password is empty")
+	}
+	if len(password) > maxBGPPasswordLength {
+		return fmt.Errorf("password is longer than %d characters", maxBGPPasswordLength)
+	}
+	if strings.ContainsAny(password, "\"\\\n") {
+		return errors.New("password must not contain quotes, backslashes or newlines")
+	}
+	return nil
+}
+
+// bgpSessions returns the endpoint names of all BGP sessions in the cluster.
+// Sessions between ToR switches and nodes are included only if includeNodes is true.
+func bgpSessions(ta *TemplateArgs, includeNodes bool) [][2]string {
+	var sessions [][2]string
+
+	for _, spine := range ta.Spines {
+		sessions = append(sessions, [2]string{"core", spine.Name})
+	}
+	for _, peer := range ta.ExternalPeers {
+		sessions = append(sessions, [2]string{"core", peer.Name})
+	}
+
+	for _, rack := range ta.Racks {
+		for spineIdx, spine := range ta.Spines {
+			if rack.ToR1.Connected(spineIdx) {
+				sessions = append(sessions, [2]string{spine.Name, rack.ToR1.Name})
+			}
+			if rack.ToR2.Connected(spineIdx) {
+				sessions = append(sessions, [2]string{spine.Name, rack.ToR2.Name})
+			}
+		}
+
+		if rack.StorageToR != nil {
+			for _, other := range ta.Racks[rack.Index+1:] {
+				sessions = append(sessions, [2]string{rack.StorageToR.Name, other.StorageToR.Name})
+			}
+		}
+
+		if !includeNodes {
+			continue
+		}
+		for _, node := range rack.Nodes() {
+			sessions = append(sessions, [2]string{rack.ToR1.Name, node.Fullname})
+			if node.PeerAddress(2) != nil {
+				sessions = append(sessions, [2]string{rack.ToR2.Name, node.Fullname})
+			}
+		}
+	}
+
+	return sessions
+}
+
+// setBGPPasswords assigns passwords to all BGP sessions if authentication is enabled
+func setBGPPasswords(ta *TemplateArgs, menu *Menu) error {
+	auth := menu.Network.BGPAuth
+	if auth == nil {
+		return nil
+	}
+
+	ta.bgpPasswords = make(map[string]string)
+	for _, s := range bgpSessions(ta, auth.Nodes) {
+		key := bgpSessionKey(s[0], s[1])
+		if password, ok := auth.Secrets[key]; ok {
+			ta.bgpPasswords[key] = password
+			continue
+		}
+		if auth.Seed == "" {
+			return fmt.Errorf("no BGP secret for the session between %s and %s", s[0], s[1])
+		}
+		ta.bgpPasswords[key] = deriveBGPPassword(auth.Seed, s[0], s[1])
+	}
+
+	for key := range auth.Secrets {
+		if _, ok := ta.bgpPasswords[key]; !ok {
+			return fmt.Errorf("BGP secret for unknown session: %s", key)
+		}
+	}
+	return nil
+}
+
+// BGPPassword returns the password of the BGP session between a and b.
+// It returns an empty string if the session is not authenticated.
+func (t TemplateArgs) BGPPassword(a, b string) string {
+	return t.bgpPasswords[bgpSessionKey(a, b)]
+}
+
+// RouterConfigMode returns the permission of router configuration files.
+// It returns 0600 if BGP sessions are authenticated, or 0 to use that of the templates.
+func (t TemplateArgs) RouterConfigMode() os.FileMode {
+	if t.bgpPasswords == nil {
+		return 0
+	}
+	return 0600
+}
+
+// ExportBGPSecrets exports the passwords of all BGP sessions in the same
+// format as a BGP secrets file
+func ExportBGPSecrets(w io.Writer, ta *TemplateArgs) error {
+	keys := make([]string, 0, len(ta.bgpPasswords))
+	for key := range ta.bgpPasswords {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]bgpSecret, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, bgpSecret{
+			Peers:    strings.SplitN(key, " ", 2),
+			Password: ta.bgpPasswords[key],
+		})
+	}
+
+	data, err := yaml.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,88 @@
+package menu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBGPPasswords(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, Boot: 1}, {SS: 1, Boot: 1}})
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ta.BGPPassword("core", "spine1") != "" {
+		t.Error("BGP sessions must not be authenticated by default")
+	}
+	if ta.RouterConfigMode() != 0 {
+		t.Errorf("unexpected mode of router configs: %o", ta.RouterConfigMode())
+	}
+
+	m.Network.BGPAuth = &BGPAuthMenu{
+		Seed:    "seed",
+		Secrets: map[string]string{bgpSessionKey("spine2", "core"): "explicit"},
+	}
+	ta, err = ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := ta.BGPPassword("spine1", "rack0-tor1")
+	if p == "" || p != ta.BGPPassword("rack0-tor1", "spine1") {
+		t.Errorf("passwords of both ends must be the same: %s", p)
+	}
+	if p == ta.BGPPassword("spine1", "rack0-tor2") {
+		t.Error("passwords of different sessions must be different")
+	}
+	if ta.BGPPassword("core", "spine2") != "explicit" {
+		t.Error("explicit secret is not used")
+	}
+	configs, err := RouterConfigs(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rc := range configs {
+		if rc.Mode != 0600 {
+			t.Errorf("%s must be readable only by the owner: %o", rc.File, rc.Mode)
+		}
+	}
+	if ta.BGPPassword("rack0-tor1", "rack0-cs1") != "" {
+		t.Error("sessions with nodes must not be authenticated unless nodes is set")
+	}
+
+	m.Network.BGPAuth.Nodes = true
+	ta, err = ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ta.BGPPassword("rack0-tor1", "rack0-cs1") == "" || ta.BGPPassword("rack1-tor2", "boot-1") == "" {
+		t.Error("sessions with nodes must be authenticated")
+	}
+
+	buf := new(bytes.Buffer)
+	err = ExportBGPSecrets(buf, ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "password: explicit") {
+		t.Errorf("unexpected secrets manifest: %s", buf.String())
+	}
+
+	m.Network.BGPAuth.Seed = ""
+	_, err = ToTemplateArgs(m)
+	if err == nil {
+		t.Error("sessions without secrets must be rejected when seed is empty")
+	}
+
+	m.Network.BGPAuth = &BGPAuthMenu{
+		Seed:    "seed",
+		Secrets: map[string]string{bgpSessionKey("spine3", "core"): "explicit"},
+	}
+	_, err = ToTemplateArgs(m)
+	if err == nil {
+		t.Error("secrets for unknown sessions must be rejected")
+	}
+}
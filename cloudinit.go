@@ -0,0 +1,128 @@
+package menu
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// instanceIDSerialLen is the length of the serial prefix in instance IDs
+const instanceIDSerialLen = 8
+
+// CloudInitContext is the context of cloud-init templates.
+// Fields are never removed or renamed; new fields may be added.
+type CloudInitContext struct {
+	// Name is the full name of the node, e.g. "boot-0" or "rack0-cs1"
+	Name string
+	// Type is the node type; "boot", "cs" or "ss"
+	Type string
+	// Node contains the addresses, the serial and the ToR addresses of the node
+	Node Node
+	// BastionAddress is the bastion address of a boot server, or nil for cs and ss
+	BastionAddress *net.IPNet
+	// Rack is the rack of the node
+	Rack Rack
+	// Resource is the VM resource of the node type
+	Resource VMResource
+
+	// ClusterID is the cluster ID used in sabakan
+	ClusterID string
+	// Spines is the list of spine switches
+	Spines []Spine
+	// Exposed is the networks exposed outside of the cluster
+	Exposed ExposedNetworks
+	// Images is the names of the image resources
+	Images []string
+	// Cluster is the whole template args of the cluster
+	Cluster *TemplateArgs
+
+	// BIRD is the content of bird_<node>.conf.  It is rendered from a template
+	// by the caller, so NewCloudInitContext leaves this empty.
+	BIRD string
+	// Networkd is the systemd-networkd configuration files of the node
+	Networkd []NetworkdFile
+	// NetworkConfig is the cloud-init network-config of the node
+	NetworkConfig *NetworkConfig
+	// MetaData is the cloud-init meta-data of the node
+	MetaData *MetaData
+}
+
+// MetaData is the cloud-init meta-data of a node
+type MetaData struct {
+	InstanceID    string `yaml:"instance-id"`
+	LocalHostname string `yaml:"local-hostname"`
+}
+
+// NodeMetaData returns the meta-data of the node.  The instance ID is derived
+// from the name and the serial, so it is stable across regenerations.
+func NodeMetaData(node Node) *MetaData {
+	serial := node.Serial
+	if len(serial) > instanceIDSerialLen {
+		serial = serial[:instanceIDSerialLen]
+	}
+	return &MetaData{
+		InstanceID:    fmt.Sprintf("iid-%s-%s", node.Fullname, serial),
+		LocalHostname: node.Fullname,
+	}
+}
+
+// ExportMetaData exports the meta-data in YAML
+func ExportMetaData(w io.Writer, md *MetaData) error {
+	return yaml.NewEncoder(w).Encode(md)
+}
+
+// NewCloudInitContext returns the context of cloud-init templates for the node
+// named fullname in the rack.
+func NewCloudInitContext(ta *TemplateArgs, rackIdx int, fullname string) (*CloudInitContext, error) {
+	if rackIdx < 0 || rackIdx >= len(ta.Racks) {
+		return nil, fmt.Errorf("rack %d does not exist", rackIdx)
+	}
+	rack := ta.Racks[rackIdx]
+
+	ctx := &CloudInitContext{
+		Name:      fullname,
+		Rack:      rack,
+		ClusterID: ta.ClusterID,
+		Spines:    ta.Spines,
+		Exposed:   ta.Network.Exposed,
+		Cluster:   ta,
+	}
+	for _, img := range ta.Images {
+		ctx.Images = append(ctx.Images, img.Name)
+	}
+
+	found := false
+	for _, boot := range rack.BootNodes {
+		if boot.Fullname == fullname {
+			ctx.Type, ctx.Node, ctx.Resource = "boot", boot.Node, ta.Boot
+			ctx.BastionAddress = boot.BastionAddress
+			ctx.NetworkConfig = BootNodeNetworkConfig(boot)
+			found = true
+		}
+	}
+	for _, l := range []struct {
+		typ      string
+		nodes    []Node
+		resource VMResource
+	}{
+		{"cs", rack.CSList, ta.CS},
+		{"ss", rack.SSList, ta.SS},
+	} {
+		for _, node := range l.nodes {
+			if node.Fullname == fullname {
+				ctx.Type, ctx.Node, ctx.Resource = l.typ, node, l.resource
+				ctx.NetworkConfig = NodeNetworkConfig(node)
+				found = true
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("node %s does not exist in %s", fullname, rack.Name)
+	}
+
+	ctx.Networkd = NodeNetworkdFiles(ctx.Node)
+	ctx.MetaData = NodeMetaData(ctx.Node)
+	return ctx, nil
+}
@@ -0,0 +1,63 @@
+package menu
+
+import (
+	"testing"
+)
+
+func TestCloudInitContext(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, SS: 1, Boot: 1}})
+	m.Network.Bastion = mustParseCIDR("10.72.48.0/26")
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rack := ta.Racks[0]
+
+	boot, err := NewCloudInitContext(ta, 0, "boot-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if boot.Type != "boot" || boot.Node.Serial != rack.BootNodes[0].Serial || boot.Rack.Name != rack.Name {
+		t.Errorf("unexpected context: %s %v %s", boot.Type, boot.Node, boot.Rack.Name)
+	}
+	if boot.BastionAddress.String() != "10.72.48.0/32" || boot.Exposed.Bastion.String() != "10.72.48.0/26" {
+		t.Errorf("unexpected bastion: %v %v", boot.BastionAddress, boot.Exposed.Bastion)
+	}
+	if boot.ClusterID != ta.ClusterID || len(boot.Spines) != 2 || boot.Cluster != ta {
+		t.Error("context must contain the cluster data")
+	}
+	if len(boot.NetworkConfig.DummyDevices["node0"].Addresses) != 2 || len(boot.Networkd) == 0 {
+		t.Error("context must contain the network configurations of the boot server")
+	}
+
+	ss, err := NewCloudInitContext(ta, 0, "rack0-ss1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss.Type != "ss" || ss.BastionAddress != nil || ss.Resource.CPU != ta.SS.CPU {
+		t.Errorf("unexpected context: %s %v %v", ss.Type, ss.BastionAddress, ss.Resource)
+	}
+
+	expected := "iid-rack0-ss1-" + rack.SSList[0].Serial[:8]
+	if ss.MetaData.InstanceID != expected || ss.MetaData.LocalHostname != "rack0-ss1" {
+		t.Errorf("unexpected meta-data: %v", ss.MetaData)
+	}
+	again, err := NewCloudInitContext(ta, 0, "rack0-ss1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *again.MetaData != *ss.MetaData {
+		t.Error("instance ID must be stable")
+	}
+
+	_, err = NewCloudInitContext(ta, 0, "rack1-cs1")
+	if err == nil {
+		t.Error("node in another rack must be an error")
+	}
+	_, err = NewCloudInitContext(ta, 1, "rack0-cs1")
+	if err == nil {
+		t.Error("non-existent rack must be an error")
+	}
+}
@@ -1,9 +1,11 @@
 package menu
 
 import (
+	"crypto/sha1"
 	"fmt"
-
 	"io"
+	"sort"
+	"strings"
 
 	"github.com/cybozu-go/placemat"
 	yaml "gopkg.in/yaml.v2"
@@ -13,8 +15,14 @@ const (
 	dockerImageBird    = "docker://quay.io/cybozu/bird:2.0"
 	dockerImageDebug   = "docker://quay.io/cybozu/ubuntu-debug:18.04"
 	dockerImageDnsmasq = "docker://quay.io/cybozu/dnsmasq:2.79"
+	dockerImageFRR     = "docker://frrouting/frr:v7.1.0"
+	dockerImageGoBGP   = "docker://quay.io/cybozu/gobgp:2.4"
 )
 
+// maxNameLength is the maximum length of a Linux network interface name
+// (IFNAMSIZ - 1).  Network names are used as bridge and tap device names.
+const maxNameLength = 15
+
 var birdContainer = placemat.PodAppSpec{
 	Name:           "bird",
 	Image:          dockerImageBird,
@@ -47,39 +55,67 @@ type cluster struct {
 	dataFolders []*placemat.DataFolderSpec
 	pods        []*placemat.PodSpec
 	nodes       []*placemat.NodeSpec
+
+	// names maps shortened network names to the original ones
+	names NetworkNames
 }
 
-// ExportCluster exports a placemat configuration to writer from TemplateArgs
-func ExportCluster(w io.Writer, ta *TemplateArgs) error {
-	cluster := generateCluster(ta)
+// NetworkNames maps shortened network names to the original ones
+type NetworkNames map[string]string
+
+// ExportCluster exports a placemat configuration to writer from TemplateArgs.
+// It returns the network names shortened in the configuration.
+func ExportCluster(w io.Writer, ta *TemplateArgs) (NetworkNames, error) {
+	cluster, err := generateCluster(ta)
+	if err != nil {
+		return nil, err
+	}
 
 	encoder := yaml.NewEncoder(w)
 	for _, n := range cluster.networks {
 		err := encoder.Encode(n)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 	for _, i := range ta.Images {
 		err := encoder.Encode(i)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 	for _, f := range cluster.dataFolders {
 		err := encoder.Encode(f)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 	for _, n := range cluster.nodes {
 		err := encoder.Encode(n)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 	for _, p := range cluster.pods {
 		err := encoder.Encode(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cluster.names, nil
+}
+
+// ExportNetworkNames exports the table of shortened network names returned
+// by ExportCluster to writer
+func ExportNetworkNames(w io.Writer, names NetworkNames) error {
+	var shortNames []string
+	for short := range names {
+		shortNames = append(shortNames, short)
+	}
+	sort.Strings(shortNames)
+
+	for _, short := range shortNames {
+		_, err := fmt.Fprintf(w, "%-*s %s\n", maxNameLength, short, names[short])
 		if err != nil {
 			return err
 		}
@@ -87,7 +123,7 @@ func ExportCluster(w io.Writer, ta *TemplateArgs) error {
 	return nil
 }
 
-func generateCluster(ta *TemplateArgs) *cluster {
+func generateCluster(ta *TemplateArgs) (*cluster, error) {
 	cluster := new(cluster)
 
 	cluster.appendExternalNetwork(ta)
@@ -100,29 +136,152 @@ func generateCluster(ta *TemplateArgs) *cluster {
 
 	cluster.appendRackNetwork(ta)
 
-	cluster.appendCoreDataFolder()
+	cluster.appendStorageNetwork(ta)
+
+	cluster.appendExternalPeerNetwork(ta)
+
+	cluster.appendCoreDataFolder(ta)
 
 	cluster.appendSpineDataFolder(ta)
 
 	cluster.appendRackDataFolder(ta)
 
+	cluster.appendStorageDataFolder(ta)
+
+	cluster.appendExternalPeerDataFolder(ta)
+
 	cluster.appendOperationDataFolder()
 
 	cluster.appendSabakanDataFolder()
 
+	cluster.appendIgnitionDataFolders(ta)
+
 	cluster.appendCorePod(ta)
 
 	cluster.appendSpinePod(ta)
 
 	cluster.appendToRPods(ta)
 
+	cluster.appendStorageToRPods(ta)
+
 	cluster.appendExtPod(ta)
 
 	cluster.appendOperationPod(ta)
 
+	cluster.appendExternalPeerPods(ta)
+
 	cluster.appendNodes(ta)
 
-	return cluster
+	err := cluster.attachOutputFiles(ta)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cluster.appendExtraDataFolders(ta)
+	if err != nil {
+		return nil, err
+	}
+
+	if ta.Network.ShortenNames {
+		cluster.shortenNames()
+	}
+
+	err = cluster.validateNames()
+	if err != nil {
+		return nil, err
+	}
+
+	return cluster, nil
+}
+
+// shortName returns name as is if it fits in an interface name.
+// Otherwise, it returns the head of name followed by a hash of the whole name.
+func shortName(name string) string {
+	if len(name) <= maxNameLength {
+		return name
+	}
+	sum := sha1.Sum([]byte(name))
+	return fmt.Sprintf("%s-%x", name[:maxNameLength-7], sum[:3])
+}
+
+func (c *cluster) shortenNames() {
+	renamed := make(map[string]string)
+	c.names = make(NetworkNames)
+	for _, n := range c.networks {
+		short := shortName(n.Name)
+		if short == n.Name {
+			continue
+		}
+		renamed[n.Name] = short
+		c.names[short] = n.Name
+		n.Name = short
+	}
+
+	for _, p := range c.pods {
+		for i, ifce := range p.Interfaces {
+			if short, ok := renamed[ifce.Network]; ok {
+				p.Interfaces[i].Network = short
+			}
+		}
+	}
+	for _, n := range c.nodes {
+		for i, ifce := range n.Interfaces {
+			if short, ok := renamed[ifce]; ok {
+				n.Interfaces[i] = short
+			}
+		}
+	}
+}
+
+// checkInterfaceName returns an error if name is not a valid Linux network interface name
+func checkInterfaceName(name string) error {
+	switch {
+	case name == "" || name == "." || name == "..":
+		return fmt.Errorf("invalid interface name: %q", name)
+	case len(name) > maxNameLength:
+		return fmt.Errorf("interface name %s is longer than %d characters", name, maxNameLength)
+	case strings.ContainsAny(name, "/: \t\n"):
+		return fmt.Errorf("interface name %q contains invalid characters", name)
+	}
+	return nil
+}
+
+func (c *cluster) validateNames() error {
+	networks := make(map[string]bool)
+	for _, n := range c.networks {
+		if len(n.Name) > maxNameLength {
+			return fmt.Errorf("network name %s is longer than %d characters; set shorten-names in Network", n.Name, maxNameLength)
+		}
+		err := checkInterfaceName(n.Name)
+		if err != nil {
+			return fmt.Errorf("network %s: %v", n.Name, err)
+		}
+		if networks[n.Name] {
+			return fmt.Errorf("duplicate network name: %s", n.Name)
+		}
+		networks[n.Name] = true
+	}
+
+	pods := make(map[string]bool)
+	for _, p := range c.pods {
+		if pods[p.Name] {
+			return fmt.Errorf("duplicate pod name: %s", p.Name)
+		}
+		pods[p.Name] = true
+		for _, ifce := range p.Interfaces {
+			if !networks[ifce.Network] {
+				return fmt.Errorf("pod %s refers to undefined network %s", p.Name, ifce.Network)
+			}
+		}
+	}
+	for _, n := range c.nodes {
+		for _, ifce := range n.Interfaces {
+			if !networks[ifce] {
+				return fmt.Errorf("node %s refers to undefined network %s", n.Name, ifce)
+			}
+		}
+	}
+	return nil
 }
 
 func (c *cluster) appendOperationPod(ta *TemplateArgs) {
@@ -195,7 +354,29 @@ func (c *cluster) appendExtPod(ta *TemplateArgs) {
 	c.pods = append(c.pods, pod)
 }
 
-func bootNode(rack *Rack, resource *VMResource) *placemat.NodeSpec {
+// seedVolume returns the localds volume of the cloud-init seed of the node.
+// localds of placemat takes only user-data and network-config, so meta-data and
+// vendor-data generated by placemat-menu are not included in the seed.
+func seedVolume(nodeName string) placemat.NodeVolumeSpec {
+	return placemat.NodeVolumeSpec{
+		Kind:          "localds",
+		Name:          "seed",
+		UserData:      fmt.Sprintf("seed_%s.yml", nodeName),
+		NetworkConfig: fmt.Sprintf("network_%s.yml", nodeName),
+	}
+}
+
+// ignitionVolume returns the volume of the data folder containing Ignition config of the node.
+// placemat cannot pass Ignition config via fw_cfg, so the image must read it from this volume.
+func ignitionVolume(nodeName string) placemat.NodeVolumeSpec {
+	return placemat.NodeVolumeSpec{
+		Kind:   "vvfat",
+		Name:   "ignition",
+		Folder: fmt.Sprintf("%s-ignition", nodeName),
+	}
+}
+
+func bootNode(rack *Rack, boot *BootNodeEntity, resource *VMResource) *placemat.NodeSpec {
 	var volumes []placemat.NodeVolumeSpec
 	if resource.Image != "" {
 		volumes = []placemat.NodeVolumeSpec{
@@ -206,13 +387,8 @@ func bootNode(rack *Rack, resource *VMResource) *placemat.NodeSpec {
 				CopyOnWrite: true,
 			},
 		}
-		if resource.CloudInitTemplate != "" {
-			volumes = append(volumes, placemat.NodeVolumeSpec{
-				Kind:          "localds",
-				Name:          "seed",
-				UserData:      fmt.Sprintf("seed_%s.yml", rack.BootNode.Fullname),
-				NetworkConfig: "network.yml",
-			})
+		if resource.HasSeed() {
+			volumes = append(volumes, seedVolume(boot.Fullname))
 		}
 	} else {
 		volumes = []placemat.NodeVolumeSpec{
@@ -224,6 +400,10 @@ func bootNode(rack *Rack, resource *VMResource) *placemat.NodeSpec {
 		}
 	}
 
+	if resource.IgnitionTemplate != "" {
+		volumes = append(volumes, ignitionVolume(boot.Fullname))
+	}
+
 	volumes = append(volumes, placemat.NodeVolumeSpec{
 		Kind:   "vvfat",
 		Name:   "sabakan",
@@ -240,23 +420,20 @@ func bootNode(rack *Rack, resource *VMResource) *placemat.NodeSpec {
 	}
 
 	return &placemat.NodeSpec{
-		Kind: "Node",
-		Name: rack.BootNode.Fullname,
-		Interfaces: []string{
-			fmt.Sprintf("%s-node1", rack.ShortName),
-			fmt.Sprintf("%s-node2", rack.ShortName),
-		},
-		Volumes: volumes,
-		CPU:     resource.CPU,
-		Memory:  resource.Memory,
-		UEFI:    resource.UEFI,
+		Kind:       "Node",
+		Name:       boot.Fullname,
+		Interfaces: nodeInterfaces(rack.ShortName, resource),
+		Volumes:    volumes,
+		CPU:        resource.CPU,
+		Memory:     resource.Memory,
+		UEFI:       resource.UEFI,
 		SMBIOS: placemat.SMBIOSConfig{
-			Serial: rack.BootNode.Serial,
+			Serial: boot.Serial,
 		},
 	}
 }
 
-func emptyNode(rackName, rackShortName, nodeName, serial string, resource *VMResource) *placemat.NodeSpec {
+func emptyNode(rack *Rack, node *Node, resource *VMResource) *placemat.NodeSpec {
 	volumes := []placemat.NodeVolumeSpec{
 		{
 			Kind: "raw",
@@ -270,6 +447,13 @@ func emptyNode(rackName, rackShortName, nodeName, serial string, resource *VMRes
 		},
 	}
 
+	if resource.HasSeed() {
+		volumes = append(volumes, seedVolume(node.Fullname))
+	}
+	if resource.IgnitionTemplate != "" {
+		volumes = append(volumes, ignitionVolume(node.Fullname))
+	}
+
 	for i, dataImg := range resource.Data {
 		volumes = append(volumes, placemat.NodeVolumeSpec{
 			Kind:        "image",
@@ -279,51 +463,83 @@ func emptyNode(rackName, rackShortName, nodeName, serial string, resource *VMRes
 		})
 	}
 
+	interfaces := nodeInterfaces(rack.ShortName, resource)
+	if node.StorageAddress != nil {
+		interfaces = append(interfaces, fmt.Sprintf("%s-stor", rack.ShortName))
+	}
+
 	return &placemat.NodeSpec{
-		Kind: "Node",
-		Name: fmt.Sprintf("%s-%s", rackName, nodeName),
-		Interfaces: []string{
-			fmt.Sprintf("%s-node1", rackShortName),
-			fmt.Sprintf("%s-node2", rackShortName),
-		},
-		Volumes: volumes,
-		CPU:     resource.CPU,
-		Memory:  resource.Memory,
-		UEFI:    resource.UEFI,
+		Kind:       "Node",
+		Name:       node.Fullname,
+		Interfaces: interfaces,
+		Volumes:    volumes,
+		CPU:        resource.CPU,
+		Memory:     resource.Memory,
+		UEFI:       resource.UEFI,
 		SMBIOS: placemat.SMBIOSConfig{
-			Serial: serial,
+			Serial: node.Serial,
 		},
 	}
 }
 
+// nodeInterfaces returns the networks connected to a node.
+// Routed NICs are connected to node1 and node2 networks one by one, and
+// bonded NICs are all connected to node1 network shared by both ToR switches.
+func nodeInterfaces(rackShortName string, resource *VMResource) []string {
+	var ifces []string
+	for i := 0; i < resource.NICs; i++ {
+		network := i + 1
+		if resource.Bond {
+			network = 1
+		}
+		ifces = append(ifces, fmt.Sprintf("%s-node%d", rackShortName, network))
+	}
+	return ifces
+}
+
 func (c *cluster) appendNodes(ta *TemplateArgs) {
 	for _, rack := range ta.Racks {
-		c.nodes = append(c.nodes, bootNode(&rack, &ta.Boot))
+		for _, boot := range rack.BootNodes {
+			c.nodes = append(c.nodes, bootNode(&rack, &boot, &ta.Boot))
+		}
 
 		for _, cs := range rack.CSList {
-			c.nodes = append(c.nodes, emptyNode(rack.Name, rack.ShortName, cs.Name, cs.Serial, &ta.CS))
+			c.nodes = append(c.nodes, emptyNode(&rack, &cs, &ta.CS))
 		}
 		for _, ss := range rack.SSList {
-			c.nodes = append(c.nodes, emptyNode(rack.Name, rack.ShortName, ss.Name, ss.Serial, &ta.SS))
+			c.nodes = append(c.nodes, emptyNode(&rack, &ss, &ta.SS))
 		}
 	}
 }
 
-func torPod(rackName, rackShortName string, tor ToR, torNumber int, ta *TemplateArgs) *placemat.PodSpec {
+func torPod(rack *Rack, tor ToR, torNumber int, ta *TemplateArgs) *placemat.PodSpec {
 
 	var spineIfs []placemat.PodInterfaceSpec
 	for i, spine := range ta.Spines {
+		if !tor.Connected(i) {
+			continue
+		}
 		spineIfs = append(spineIfs,
 			placemat.PodInterfaceSpec{
-				Network:   fmt.Sprintf("%s-to-%s-%d", spine.ShortName, rackShortName, torNumber),
+				Network:   fmt.Sprintf("%s-to-%s-%d", spine.ShortName, rack.ShortName, torNumber),
 				Addresses: []string{tor.SpineAddresses[i].String()},
 			},
 		)
 	}
+	nodeNetwork := torNumber
+	if rack.node2Network == nil {
+		nodeNetwork = 1
+	}
 	spineIfs = append(spineIfs, placemat.PodInterfaceSpec{
-		Network:   fmt.Sprintf("%s-node%d", rackShortName, torNumber),
+		Network:   fmt.Sprintf("%s-node%d", rack.ShortName, nodeNetwork),
 		Addresses: []string{tor.NodeAddress.String()},
 	})
+	if tor.BondAddress != nil {
+		spineIfs = append(spineIfs, placemat.PodInterfaceSpec{
+			Network:   fmt.Sprintf("%s-node1", rack.ShortName),
+			Addresses: []string{tor.BondAddress.String()},
+		})
+	}
 
 	dhcpRelayArgs := []string{
 		"--keep-in-foreground",
@@ -331,33 +547,23 @@ func torPod(rackName, rackShortName string, tor ToR, torNumber int, ta *Template
 		"--log-facility=-",
 	}
 	for _, r := range ta.Racks {
-		if r.Name == rackName {
+		if r.Name == rack.Name {
 			continue
 		}
-		dhcpRelayArgs = append(dhcpRelayArgs, "--dhcp-relay")
-		dhcpRelayArgs = append(dhcpRelayArgs, tor.NodeAddress.IP.String()+","+r.BootNode.Node0Address.IP.String())
+		for _, boot := range r.BootNodes {
+			dhcpRelayArgs = append(dhcpRelayArgs, "--dhcp-relay")
+			dhcpRelayArgs = append(dhcpRelayArgs, tor.NodeAddress.IP.String()+","+boot.Node0Address.IP.String())
+		}
 	}
 
 	return &placemat.PodSpec{
 		Kind:       "Pod",
-		Name:       fmt.Sprintf("%s-tor%d", rackName, torNumber),
+		Name:       fmt.Sprintf("%s-tor%d", rack.Name, torNumber),
 		Interfaces: spineIfs,
-		Volumes: []*placemat.PodVolumeSpec{
-			{
-				Name:     "config",
-				Kind:     "host",
-				Folder:   fmt.Sprintf("%s-tor%d-data", rackName, torNumber),
-				ReadOnly: true,
-			},
-			{
-				Name: "run",
-				Kind: "empty",
-			},
-		},
-		Apps: []*placemat.PodAppSpec{
-			&birdContainer,
+		Volumes:    ta.torBackend().Volumes(fmt.Sprintf("%s-tor%d-data", rack.Name, torNumber)),
+		Apps: append(ta.torBackend().Containers(),
 			&debugContainer,
-			{
+			&placemat.PodAppSpec{
 				Name:           "dhcp-relay",
 				Image:          dockerImageDnsmasq,
 				ReadOnlyRootfs: true,
@@ -368,19 +574,43 @@ func torPod(rackName, rackShortName string, tor ToR, torNumber int, ta *Template
 				},
 				Args: dhcpRelayArgs,
 			},
-		},
+		),
 	}
 }
 
 func (c *cluster) appendToRPods(ta *TemplateArgs) {
 	for _, rack := range ta.Racks {
 		c.pods = append(c.pods,
-			torPod(rack.Name, rack.ShortName, rack.ToR1, 1, ta),
-			torPod(rack.Name, rack.ShortName, rack.ToR2, 2, ta),
+			torPod(&rack, rack.ToR1, 1, ta),
+			torPod(&rack, rack.ToR2, 2, ta),
 		)
 	}
 }
 
+func (c *cluster) appendStorageToRPods(ta *TemplateArgs) {
+	for _, rack := range ta.Racks {
+		if rack.StorageToR == nil {
+			continue
+		}
+		c.pods = append(c.pods, &placemat.PodSpec{
+			Kind: "Pod",
+			Name: rack.StorageToR.Name,
+			Interfaces: []placemat.PodInterfaceSpec{
+				{
+					Network:   "stor-backbone",
+					Addresses: []string{rack.StorageToR.BackboneAddress.String()},
+				},
+				{
+					Network:   fmt.Sprintf("%s-stor", rack.ShortName),
+					Addresses: []string{rack.StorageToR.NodeAddress.String()},
+				},
+			},
+			Volumes: ta.torBackend().Volumes(fmt.Sprintf("%s-data", rack.StorageToR.Name)),
+			Apps:    append(ta.torBackend().Containers(), &debugContainer),
+		})
+	}
+}
+
 func (c *cluster) appendCorePod(ta *TemplateArgs) {
 	var interfaces []placemat.PodInterfaceSpec
 	interfaces = append(interfaces, placemat.PodInterfaceSpec{
@@ -391,6 +621,15 @@ func (c *cluster) appendCorePod(ta *TemplateArgs) {
 		Network:   "bmc",
 		Addresses: []string{ta.Core.BMCAddress.String()},
 	})
+	for _, rack := range ta.Racks {
+		if rack.BMCGatewayAddress == nil {
+			continue
+		}
+		interfaces = append(interfaces, placemat.PodInterfaceSpec{
+			Network:   fmt.Sprintf("%s-bmc", rack.ShortName),
+			Addresses: []string{rack.BMCGatewayAddress.String()},
+		})
+	}
 	for i, spine := range ta.Spines {
 		interfaces = append(interfaces, placemat.PodInterfaceSpec{
 			Network: fmt.Sprintf("core-to-%s", spine.ShortName),
@@ -411,32 +650,55 @@ func (c *cluster) appendCorePod(ta *TemplateArgs) {
 			ta.Core.OperationAddress.String(),
 		},
 	})
+	for _, peer := range ta.ExternalPeers {
+		interfaces = append(interfaces, placemat.PodInterfaceSpec{
+			Network:   fmt.Sprintf("core-to-%s", peer.Name),
+			Addresses: []string{peer.CoreAddress.String()},
+		})
+	}
 	c.pods = append(c.pods, &placemat.PodSpec{
 		Kind:        "Pod",
 		Name:        "core",
 		InitScripts: []string{"setup-iptables"},
 		Interfaces:  interfaces,
-		Volumes: []*placemat.PodVolumeSpec{
-			{
-				Name:     "config",
-				Kind:     "host",
-				Folder:   "core-data",
-				ReadOnly: true,
+		Volumes:     ta.coreBackend().Volumes("core-data"),
+		Apps:        append(ta.coreBackend().Containers(), &debugContainer),
+	})
+}
+
+func (c *cluster) appendExternalPeerPods(ta *TemplateArgs) {
+	for _, peer := range ta.ExternalPeers {
+		c.pods = append(c.pods, &placemat.PodSpec{
+			Kind: "Pod",
+			Name: peer.Name,
+			Interfaces: []placemat.PodInterfaceSpec{
+				{
+					Network:   fmt.Sprintf("core-to-%s", peer.Name),
+					Addresses: []string{peer.Address.String()},
+				},
 			},
-			{
-				Name: "run",
-				Kind: "empty",
+			Volumes: []*placemat.PodVolumeSpec{
+				{
+					Name:     "config",
+					Kind:     "host",
+					Folder:   fmt.Sprintf("%s-data", peer.Name),
+					ReadOnly: true,
+				},
+				{
+					Name: "run",
+					Kind: "empty",
+				},
 			},
-		},
-		Apps: []*placemat.PodAppSpec{
-			&birdContainer,
-			&debugContainer,
-		},
-	})
+			Apps: []*placemat.PodAppSpec{
+				&birdContainer,
+				&debugContainer,
+			},
+		})
+	}
 }
 
 func (c *cluster) appendSpinePod(ta *TemplateArgs) {
-	for _, spine := range ta.Spines {
+	for spineIdx, spine := range ta.Spines {
 		var ifces []placemat.PodInterfaceSpec
 
 		ifces = append(ifces,
@@ -446,42 +708,82 @@ func (c *cluster) appendSpinePod(ta *TemplateArgs) {
 			},
 		)
 		for i, rack := range ta.Racks {
-			ifces = append(ifces,
-				placemat.PodInterfaceSpec{
-					Network:   fmt.Sprintf("%s-to-%s-1", spine.ShortName, rack.ShortName),
-					Addresses: []string{spine.ToR1Address(i).String()},
-				},
-				placemat.PodInterfaceSpec{
-					Network:   fmt.Sprintf("%s-to-%s-2", spine.ShortName, rack.ShortName),
-					Addresses: []string{spine.ToR2Address(i).String()},
-				},
-			)
+			if rack.ToR1.Connected(spineIdx) {
+				ifces = append(ifces,
+					placemat.PodInterfaceSpec{
+						Network:   fmt.Sprintf("%s-to-%s-1", spine.ShortName, rack.ShortName),
+						Addresses: []string{spine.ToR1Address(i).String()},
+					},
+				)
+			}
+			if rack.ToR2.Connected(spineIdx) {
+				ifces = append(ifces,
+					placemat.PodInterfaceSpec{
+						Network:   fmt.Sprintf("%s-to-%s-2", spine.ShortName, rack.ShortName),
+						Addresses: []string{spine.ToR2Address(i).String()},
+					},
+				)
+			}
 		}
 
 		c.pods = append(c.pods, &placemat.PodSpec{
 			Kind:       "Pod",
 			Name:       spine.Name,
 			Interfaces: ifces,
-			Volumes: []*placemat.PodVolumeSpec{
-				{
-					Name:     "config",
-					Kind:     "host",
-					Folder:   fmt.Sprintf("%s-data", spine.Name),
-					ReadOnly: true,
-				},
-				{
-					Name: "run",
-					Kind: "empty",
-				},
-			},
-			Apps: []*placemat.PodAppSpec{
-				&birdContainer,
-				&debugContainer,
-			},
+			Volumes:    ta.spineBackend().Volumes(fmt.Sprintf("%s-data", spine.Name)),
+			Apps:       append(ta.spineBackend().Containers(), &debugContainer),
 		})
 	}
 }
 
+func (c *cluster) appendIgnitionDataFolders(ta *TemplateArgs) {
+	for _, rack := range ta.Racks {
+		var boots []Node
+		for _, boot := range rack.BootNodes {
+			boots = append(boots, boot.Node)
+		}
+		for _, l := range []struct {
+			nodes    []Node
+			resource VMResource
+		}{
+			{boots, ta.Boot},
+			{rack.CSList, ta.CS},
+			{rack.SSList, ta.SS},
+		} {
+			if l.resource.IgnitionTemplate == "" {
+				continue
+			}
+			for _, node := range l.nodes {
+				c.dataFolders = append(c.dataFolders, &placemat.DataFolderSpec{
+					Kind: "DataFolder",
+					Name: fmt.Sprintf("%s-ignition", node.Fullname),
+					Files: []placemat.DataFolderFileSpec{
+						{
+							Name: "config.ign",
+							File: fmt.Sprintf("ignition_%s.ign", node.Fullname),
+						},
+					},
+				})
+			}
+		}
+	}
+}
+
+func (c *cluster) appendExtraDataFolders(ta *TemplateArgs) error {
+	names := make(map[string]bool)
+	for _, f := range c.dataFolders {
+		names[f.Name] = true
+	}
+	for _, f := range ta.DataFolders {
+		if names[f.Name] {
+			return fmt.Errorf("duplicate DataFolder: %s", f.Name)
+		}
+		names[f.Name] = true
+		c.dataFolders = append(c.dataFolders, f)
+	}
+	return nil
+}
+
 func (c *cluster) appendOperationDataFolder() {
 	c.dataFolders = append(c.dataFolders,
 		&placemat.DataFolderSpec{
@@ -509,60 +811,47 @@ func (c *cluster) appendSabakanDataFolder() {
 func (c *cluster) appendRackDataFolder(ta *TemplateArgs) {
 	for _, rack := range ta.Racks {
 		c.dataFolders = append(c.dataFolders,
-			&placemat.DataFolderSpec{
-				Kind: "DataFolder",
-				Name: fmt.Sprintf("%s-tor1-data", rack.Name),
-				Files: []placemat.DataFolderFileSpec{
-					{
-						Name: "bird.conf",
-						File: fmt.Sprintf("bird_%s-tor1.conf", rack.Name),
-					},
-				},
-			},
-			&placemat.DataFolderSpec{
-				Kind: "DataFolder",
-				Name: fmt.Sprintf("%s-tor2-data", rack.Name),
-				Files: []placemat.DataFolderFileSpec{
-					{
-						Name: "bird.conf",
-						File: fmt.Sprintf("bird_%s-tor2.conf", rack.Name),
-					},
-				},
-			},
+			routerDataFolder(ta.torBackend(), "rack-tor", rack.ToR1.Name),
+			routerDataFolder(ta.torBackend(), "rack-tor", rack.ToR2.Name),
 		)
 	}
 }
 
-func (c *cluster) appendCoreDataFolder() {
-	c.dataFolders = append(c.dataFolders,
-		&placemat.DataFolderSpec{
-			Kind: "DataFolder",
-			Name: "core-data",
-			Files: []placemat.DataFolderFileSpec{
-				{
-					Name: "bird.conf",
-					File: "bird_core.conf",
-				},
-			},
-		})
+func (c *cluster) appendStorageDataFolder(ta *TemplateArgs) {
+	for _, rack := range ta.Racks {
+		if rack.StorageToR == nil {
+			continue
+		}
+		c.dataFolders = append(c.dataFolders, routerDataFolder(ta.torBackend(), "rack-stor", rack.StorageToR.Name))
+	}
 }
 
-func (c *cluster) appendSpineDataFolder(ta *TemplateArgs) {
-	for _, spine := range ta.Spines {
+func (c *cluster) appendCoreDataFolder(ta *TemplateArgs) {
+	c.dataFolders = append(c.dataFolders, routerDataFolder(ta.coreBackend(), "core", "core"))
+}
+
+func (c *cluster) appendExternalPeerDataFolder(ta *TemplateArgs) {
+	for _, peer := range ta.ExternalPeers {
 		c.dataFolders = append(c.dataFolders,
 			&placemat.DataFolderSpec{
 				Kind: "DataFolder",
-				Name: fmt.Sprintf("%s-data", spine.Name),
+				Name: fmt.Sprintf("%s-data", peer.Name),
 				Files: []placemat.DataFolderFileSpec{
 					{
 						Name: "bird.conf",
-						File: fmt.Sprintf("bird_%s.conf", spine.Name),
+						File: fmt.Sprintf("bird_%s.conf", peer.Name),
 					},
 				},
 			})
 	}
 }
 
+func (c *cluster) appendSpineDataFolder(ta *TemplateArgs) {
+	for _, spine := range ta.Spines {
+		c.dataFolders = append(c.dataFolders, routerDataFolder(ta.spineBackend(), "spine", spine.Name))
+	}
+}
+
 func (c *cluster) appendRackNetwork(ta *TemplateArgs) {
 	for _, rack := range ta.Racks {
 		c.networks = append(
@@ -572,6 +861,12 @@ func (c *cluster) appendRackNetwork(ta *TemplateArgs) {
 				Name: fmt.Sprintf("%s-node1", rack.ShortName),
 				Type: "internal",
 			},
+		)
+		if rack.node2Network == nil {
+			continue
+		}
+		c.networks = append(
+			c.networks,
 			&placemat.NetworkSpec{
 				Kind: "Network",
 				Name: fmt.Sprintf("%s-node2", rack.ShortName),
@@ -581,22 +876,47 @@ func (c *cluster) appendRackNetwork(ta *TemplateArgs) {
 	}
 }
 
+func (c *cluster) appendStorageNetwork(ta *TemplateArgs) {
+	if ta.Network.Storage == nil {
+		return
+	}
+	c.networks = append(c.networks, &placemat.NetworkSpec{
+		Kind: "Network",
+		Name: "stor-backbone",
+		Type: "internal",
+	})
+	for _, rack := range ta.Racks {
+		c.networks = append(c.networks, &placemat.NetworkSpec{
+			Kind: "Network",
+			Name: fmt.Sprintf("%s-stor", rack.ShortName),
+			Type: "internal",
+		})
+	}
+}
+
 func (c *cluster) appendSpineToRackNetwork(ta *TemplateArgs) {
-	for _, spine := range ta.Spines {
+	for spineIdx, spine := range ta.Spines {
 		for _, rack := range ta.Racks {
-			c.networks = append(
-				c.networks,
-				&placemat.NetworkSpec{
-					Kind: "Network",
-					Name: fmt.Sprintf("%s-to-%s-1", spine.ShortName, rack.ShortName),
-					Type: "internal",
-				},
-				&placemat.NetworkSpec{
-					Kind: "Network",
-					Name: fmt.Sprintf("%s-to-%s-2", spine.ShortName, rack.ShortName),
-					Type: "internal",
-				},
-			)
+			if rack.ToR1.Connected(spineIdx) {
+				c.networks = append(
+					c.networks,
+					&placemat.NetworkSpec{
+						Kind: "Network",
+						Name: fmt.Sprintf("%s-to-%s-1", spine.ShortName, rack.ShortName),
+						Type: "internal",
+					},
+				)
+			}
+			if rack.ToR2.Connected(spineIdx) {
+				c.networks = append(
+					c.networks,
+					&placemat.NetworkSpec{
+						Kind: "Network",
+						Name: fmt.Sprintf("%s-to-%s-2", spine.ShortName, rack.ShortName),
+						Type: "internal",
+					},
+				)
+			}
 		}
 	}
 }
@@ -637,6 +957,16 @@ func (c *cluster) appendCoreNetwork(ta *TemplateArgs) {
 	)
 }
 
+func (c *cluster) appendExternalPeerNetwork(ta *TemplateArgs) {
+	for _, peer := range ta.ExternalPeers {
+		c.networks = append(c.networks, &placemat.NetworkSpec{
+			Kind: "Network",
+			Name: fmt.Sprintf("core-to-%s", peer.Name),
+			Type: "internal",
+		})
+	}
+}
+
 func (c *cluster) appendBMCNetwork(ta *TemplateArgs) {
 	c.networks = append(
 		c.networks,
@@ -647,10 +977,22 @@ func (c *cluster) appendBMCNetwork(ta *TemplateArgs) {
 			Address: addToIPNet(ta.Network.BMC, offsetBMCHost).String(),
 		},
 	)
+
+	for _, rack := range ta.Racks {
+		if rack.BMCGatewayAddress == nil {
+			continue
+		}
+		c.networks = append(c.networks, &placemat.NetworkSpec{
+			Kind: "Network",
+			Name: fmt.Sprintf("%s-bmc", rack.ShortName),
+			Type: "internal",
+		})
+	}
 }
 
-// ExportEmptyNetworkConfig export empty network-config file used in cloud-init
-func ExportEmptyNetworkConfig(w io.Writer) error {
-	_, err := fmt.Fprintln(w, "version: 2\nethernets: {}")
-	return err
+// coreBMCInterface returns the interface of the core in the BMC network of the rack.
+// Interfaces of the core are named in the order of appendCorePod; those to the
+// BMC networks of racks follow the interfaces to internet and bmc networks.
+func coreBMCInterface(rackIdx int) string {
+	return fmt.Sprintf("eth%d", 2+rackIdx)
 }
@@ -0,0 +1,555 @@
+package menu
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cybozu-go/placemat"
+)
+
+func testMenu(spines int, racks []RackMenu) *Menu {
+	return &Menu{
+		Network: &NetworkMenu{
+			NodeBase:      net.ParseIP("10.69.0.0").To4(),
+			NodeRangeSize: 6,
+			NodeRangeMask: 26,
+			NodeIPPerNode: 3,
+			BMC:           mustParseCIDR("10.72.16.0/20"),
+			BMCBase:       net.ParseIP("10.72.17.0").To4(),
+			BMCRangeSize:  5,
+			BMCRangeMask:  20,
+			ASNBase:       64600,
+			Internet:      mustParseCIDR("10.0.0.0/24"),
+			CoreSpine:     mustParseCIDR("10.0.2.0/31"),
+			CoreExternal:  mustParseCIDR("10.0.3.0/24"),
+			CoreOperation: mustParseCIDR("10.0.4.0/24"),
+			SpineTor:      net.ParseIP("10.0.1.0"),
+			Bastion:       mustParseCIDR("10.72.48.0/26"),
+			LoadBalancer:  mustParseCIDR("10.72.32.0/20"),
+			Ingress:       mustParseCIDR("10.72.48.64/26"),
+			Global:        mustParseCIDR("172.17.0.0/24"),
+		},
+		Inventory: &InventoryMenu{
+			ClusterID: "dev0",
+			Spine:     spines,
+			Rack:      racks,
+		},
+		Nodes: []*NodeMenu{
+			{Type: BootNode, CPU: 1, Memory: "1G"},
+			{Type: CSNode, CPU: 1, Memory: "1G"},
+			{Type: SSNode, CPU: 1, Memory: "1G"},
+		},
+	}
+}
+
+func TestShortName(t *testing.T) {
+	t.Parallel()
+
+	if shortName("s1-to-r0-1") != "s1-to-r0-1" {
+		t.Error("short name must not be changed")
+	}
+
+	long := "s12-to-r10-2345"
+	if shortName(long) != long {
+		t.Error("name of 15 characters must not be changed")
+	}
+
+	long = "s12-to-r100-1234"
+	short := shortName(long)
+	if len(short) > maxNameLength {
+		t.Errorf("too long: %s", short)
+	}
+	if !strings.HasPrefix(short, "s12-to-r") {
+		t.Errorf("unexpected short name: %s", short)
+	}
+	if short != shortName(long) {
+		t.Error("short name must be deterministic")
+	}
+	if short == shortName("s12-to-r100-1235") {
+		t.Error("short names must differ")
+	}
+}
+
+func TestShortenNames(t *testing.T) {
+	t.Parallel()
+
+	long := "s100-to-r1000-1"
+	longer := "s100-to-r1000-12"
+	c := &cluster{
+		networks: []*placemat.NetworkSpec{
+			{Kind: "Network", Name: long, Type: "internal"},
+			{Kind: "Network", Name: longer, Type: "internal"},
+		},
+		pods: []*placemat.PodSpec{
+			{
+				Kind: "Pod",
+				Name: "spine100",
+				Interfaces: []placemat.PodInterfaceSpec{
+					{Network: long},
+					{Network: longer},
+				},
+			},
+		},
+		nodes: []*placemat.NodeSpec{
+			{Kind: "Node", Name: "rack1000-cs1", Interfaces: []string{longer}},
+		},
+	}
+
+	err := c.validateNames()
+	if err == nil {
+		t.Error("long network names must be rejected")
+	}
+
+	c.shortenNames()
+	err = c.validateNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.networks[0].Name != long {
+		t.Errorf("%s must not be shortened", long)
+	}
+	short := c.networks[1].Name
+	if short != shortName(longer) {
+		t.Errorf("unexpected short name: %s", short)
+	}
+	if c.pods[0].Interfaces[1].Network != short || c.nodes[0].Interfaces[0] != short {
+		t.Error("interfaces are not renamed")
+	}
+	if len(c.names) != 1 || c.names[short] != longer {
+		t.Errorf("unexpected name table: %v", c.names)
+	}
+
+	c.nodes[0].Interfaces = append(c.nodes[0].Interfaces, "r0-node1")
+	err = c.validateNames()
+	if err == nil {
+		t.Error("undefined network must be rejected")
+	}
+}
+
+func TestInterfaceNames(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		valid bool
+	}{
+		{"eth0", true},
+		{"bond0", true},
+		{"s100-to-r1000-1", true},
+		{"s100-to-r1000-12", false},
+		{"", false},
+		{"..", false},
+		{"r0/node1", false},
+		{"r0:node1", false},
+		{"r0 node1", false},
+	}
+	for _, c := range cases {
+		err := checkInterfaceName(c.name)
+		if c.valid && err != nil {
+			t.Errorf("%q must be valid: %v", c.name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%q must be rejected", c.name)
+		}
+	}
+
+	c := &cluster{
+		networks: []*placemat.NetworkSpec{
+			{Kind: "Network", Name: "r0:node1", Type: "internal"},
+		},
+	}
+	err := c.validateNames()
+	if err == nil {
+		t.Error("invalid network name must be rejected")
+	}
+}
+
+func TestExportNetworkNames(t *testing.T) {
+	t.Parallel()
+
+	names := NetworkNames{
+		"n-0123456789ab": "s100-to-r1000-12",
+		"n-00000000000a": "s100-to-r1000-13",
+	}
+	buf := new(bytes.Buffer)
+	err := ExportNetworkNames(buf, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "n-00000000000a  s100-to-r1000-13\nn-0123456789ab  s100-to-r1000-12\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected table:\n%s", buf.String())
+	}
+}
+
+func TestGenerateCluster(t *testing.T) {
+	t.Parallel()
+
+	ta, err := ToTemplateArgs(testMenu(2, []RackMenu{{CS: 2, Boot: 1}, {CS: 2, SS: 2, Boot: 1}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta.Network.ShortenNames = true
+	c, err := generateCluster(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.names) != 0 {
+		t.Errorf("no names should be shortened: %v", c.names)
+	}
+}
+
+func TestPartialMesh(t *testing.T) {
+	t.Parallel()
+
+	racks := []RackMenu{
+		{CS: 1, Boot: 1},
+		{CS: 1, Boot: 1, Spines: []int{2}, DownLinks: []LinkMenu{{Spine: 2, ToR: 1}}},
+		{CS: 1, Boot: 1, ToRSpines: map[int][]int{1: {1}, 2: {2}}},
+	}
+	ta, err := ToTemplateArgs(testMenu(2, racks))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rack := ta.Racks[1]
+	if rack.ToR1.Connected(0) || rack.ToR2.Connected(0) {
+		t.Error("rack1 must not be connected to spine1")
+	}
+	if !rack.ToR1.Down(1) || rack.ToR2.Down(1) {
+		t.Error("only the link between spine2 and rack1-tor1 must be down")
+	}
+	if rack.ToR1.NodeInterface != "eth1" {
+		t.Errorf("unexpected node interface: %s", rack.ToR1.NodeInterface)
+	}
+
+	c, err := generateCluster(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range c.networks {
+		if strings.HasPrefix(n.Name, "s1-to-r1-") || n.Name == "s2-to-r2-1" || n.Name == "s1-to-r2-2" {
+			t.Errorf("%s must not exist", n.Name)
+		}
+	}
+
+	rack = ta.Racks[2]
+	if !rack.ToR1.Connected(0) || rack.ToR1.Connected(1) || rack.ToR2.Connected(0) || !rack.ToR2.Connected(1) {
+		t.Error("rack2-tor1 must be wired only to spine1, and rack2-tor2 only to spine2")
+	}
+	if rack.ToR1.NodeInterface != "eth1" || rack.ToR2.NodeInterface != "eth1" {
+		t.Errorf("unexpected node interfaces: %s, %s", rack.ToR1.NodeInterface, rack.ToR2.NodeInterface)
+	}
+	for _, p := range c.pods {
+		if p.Name != "rack1-tor1" {
+			continue
+		}
+		if len(p.Interfaces) != 2 || p.Interfaces[0].Network != "s2-to-r1-1" {
+			t.Errorf("unexpected interfaces: %v", p.Interfaces)
+		}
+	}
+}
+
+func TestBondedNodes(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, SS: 1, Boot: 1}})
+	m.Nodes[1].NICs = 4
+	m.Nodes[1].Bond = true
+	m.Nodes[2].NICs = 1
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rack := ta.Racks[0]
+	if rack.ToR2.BondAddress.String() != "10.69.0.66/26" || rack.ToR2.BondInterface != "eth3" {
+		t.Errorf("unexpected bond address of ToR-2: %v %s", rack.ToR2.BondAddress, rack.ToR2.BondInterface)
+	}
+	cs := rack.CSList[0]
+	if cs.PeerAddress(2).String() != cs.Node1Address.String() || cs.ToR2Address.String() != "10.69.0.66/26" {
+		t.Errorf("unexpected addresses of bonded node: %v", cs)
+	}
+	ss := rack.SSList[0]
+	if ss.PeerAddress(2) != nil || ss.ToR2Address != nil {
+		t.Errorf("ss must not be connected to ToR-2: %v", ss)
+	}
+
+	c, err := generateCluster(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range c.nodes {
+		switch n.Name {
+		case "rack0-cs1":
+			if !reflect.DeepEqual(n.Interfaces, []string{"r0-node1", "r0-node1", "r0-node1", "r0-node1"}) {
+				t.Errorf("unexpected interfaces: %v", n.Interfaces)
+			}
+		case "rack0-ss1":
+			if !reflect.DeepEqual(n.Interfaces, []string{"r0-node1"}) {
+				t.Errorf("unexpected interfaces: %v", n.Interfaces)
+			}
+		}
+	}
+
+	m.Network.NodeIPPerNode = 2
+	_, err = ToTemplateArgs(m)
+	if err == nil {
+		t.Error("boot node with routed NICs requires node-ip-per-node 3")
+	}
+
+	m.Nodes[0].Bond = true
+	ta, err = ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rack = ta.Racks[0]
+	if rack.ToR2.NodeAddress.String() != "10.69.0.66/26" || rack.ToR2.BondAddress != nil {
+		t.Errorf("unexpected address of ToR-2: %v", rack.ToR2)
+	}
+	if ta.Racks[0].node2Network != nil {
+		t.Error("node2 network must not exist")
+	}
+
+	// boot nodes with routed NICs are not validated if there are no boot nodes
+	m.Nodes[0].Bond = false
+	m.Inventory.Rack = []RackMenu{{CS: 1, SS: 1, Boot: 0}}
+	_, err = ToTemplateArgs(m)
+	if err != nil {
+		t.Error("unused node types must not be validated:", err)
+	}
+}
+
+func TestBootNodes(t *testing.T) {
+	t.Parallel()
+
+	ta, err := ToTemplateArgs(testMenu(2, []RackMenu{{CS: 1, Boot: 2}, {CS: 1}, {CS: 1, Boot: 1}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{
+		{"boot-0", "10.69.0.3/32", "10.72.48.0/32", "boot-1", "10.69.0.4/32", "10.72.48.1/32"},
+		{},
+		{"boot-2", "10.69.1.131/32", "10.72.48.2/32"},
+	}
+	for i, rack := range ta.Racks {
+		var actual []string
+		for _, boot := range rack.BootNodes {
+			actual = append(actual, boot.Fullname, boot.Node0Address.String(), boot.BastionAddress.String())
+		}
+		if len(actual) != len(expected[i]) || (len(actual) > 0 && !reflect.DeepEqual(actual, expected[i])) {
+			t.Errorf("unexpected boot servers in rack%d: %v", i, actual)
+		}
+	}
+	if ta.Racks[0].CSList[0].Node0Address.String() != "10.69.0.5/32" {
+		t.Errorf("unexpected cs address: %v", ta.Racks[0].CSList[0].Node0Address)
+	}
+	if ta.Racks[1].CSList[0].Node0Address.String() != "10.69.0.195/32" {
+		t.Errorf("unexpected cs address: %v", ta.Racks[1].CSList[0].Node0Address)
+	}
+
+	c, err := generateCluster(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range c.pods {
+		if p.Name != "rack1-tor1" {
+			continue
+		}
+		args := p.Apps[2].Args
+		expected := []string{"--dhcp-relay", "10.69.1.1,10.69.0.3", "--dhcp-relay", "10.69.1.1,10.69.0.4", "--dhcp-relay", "10.69.1.1,10.69.1.131"}
+		if !reflect.DeepEqual(args[3:], expected) {
+			t.Errorf("unexpected dhcp-relay args: %v", args)
+		}
+	}
+}
+
+func TestBMCNetwork(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, Boot: 1}, {CS: 1, Boot: 1}})
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rack := ta.Racks[1]
+	if rack.CSList[0].BMCAddress.String() != "10.72.17.36/20" {
+		t.Errorf("unexpected BMC address: %v", rack.CSList[0].BMCAddress)
+	}
+
+	if rack.BMCGatewayAddress != nil {
+		t.Error("BMC network must be shared")
+	}
+
+	m.Network.BMCRangeMask = 27
+	ta, err = ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rack = ta.Racks[1]
+	if rack.BMCNetwork.String() != "10.72.17.32/27" || rack.BMCGatewayAddress.String() != "10.72.17.33/27" {
+		t.Errorf("unexpected BMC network: %v %v", rack.BMCNetwork, rack.BMCGatewayAddress)
+	}
+	if rack.BootNodes[0].BMCAddress.String() != "10.72.17.35/27" {
+		t.Errorf("unexpected BMC address: %v", rack.BootNodes[0].BMCAddress)
+	}
+
+	c, err := generateCluster(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	networks := make(map[string]bool)
+	for _, n := range c.networks {
+		networks[n.Name] = true
+	}
+	if !networks["r0-bmc"] || !networks["r1-bmc"] {
+		t.Error("each rack must have its own BMC network")
+	}
+	for _, p := range c.pods {
+		if p.Name != "core" {
+			continue
+		}
+		ifce := p.Interfaces[2+rack.Index]
+		if ifce.Network != "r1-bmc" || ifce.Addresses[0] != "10.72.17.33/27" {
+			t.Errorf("core is not connected to r1-bmc at %s: %v", coreBMCInterface(rack.Index), p.Interfaces)
+		}
+	}
+
+	found := false
+	for _, s := range ta.CoreRouter().Statics {
+		if s.Prefix.String() == "10.72.17.32/27" && s.Interface == "eth3" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("core does not route r1-bmc: %v", ta.CoreRouter().Statics)
+	}
+
+	m.Network.RoutingPolicy.Filter = &RouteFilterMenu{}
+	ta, err = ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prefixSetMatch(t, ta.ToRImportPrefixes(), mustParseCIDR("10.72.17.32/27")) {
+		t.Errorf("ToR switches must accept BMC networks: %s", ta.ToRImportPrefixes())
+	}
+	m.Network.RoutingPolicy.Filter = nil
+
+	m.Network.BMCBase = net.ParseIP("10.72.31.224").To4()
+	_, err = ToTemplateArgs(m)
+	if err == nil {
+		t.Error("BMC addresses out of bmc-ipv4-pool must be an error")
+	}
+	m.Network.BMCBase = net.ParseIP("10.72.17.0").To4()
+
+	// rack1-cs1 is at the offset 4 which is out of the range size 2
+	m.Network.BMCRangeSize = 2
+	m.Network.BMCRangeMask = 20
+	_, err = ToTemplateArgs(m)
+	if err == nil {
+		t.Error("BMC addresses beyond bmc-ipv4-range-size must be an error")
+	}
+
+	// rack0-cs1 at 10.72.17.4 is out of 10.72.17.0/30
+	m.Network.BMCRangeSize = 5
+	m.Network.BMCRangeMask = 30
+	_, err = ToTemplateArgs(m)
+	if err == nil {
+		t.Error("BMC addresses beyond bmc-ipv4-range-mask must be an error")
+	}
+}
+
+func TestStorageNetwork(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, SS: 1, Boot: 1}, {SS: 1, Boot: 1}})
+	m.Network.Storage = mustParseCIDR("10.70.0.0/20")
+	m.Network.StorageRangeSize = 6
+	m.Network.StorageBackbone = mustParseCIDR("10.70.255.0/24")
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rack := ta.Racks[1]
+	if rack.StorageToR.NodeAddress.String() != "10.70.0.65/26" || rack.StorageToR.BackboneAddress.String() != "10.70.255.2/24" {
+		t.Errorf("unexpected storage ToR: %v", rack.StorageToR)
+	}
+	if rack.SSList[0].StorageAddress.String() != "10.70.0.68/26" {
+		t.Errorf("unexpected storage address: %v", rack.SSList[0].StorageAddress)
+	}
+	if ta.Racks[0].CSList[0].StorageAddress != nil || rack.BootNodes[0].StorageAddress != nil {
+		t.Error("cs and boot must not be connected to storage network")
+	}
+
+	c, err := generateCluster(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range c.nodes {
+		if n.Name == "rack1-ss1" && n.Interfaces[len(n.Interfaces)-1] != "r1-stor" {
+			t.Errorf("ss is not connected to storage network: %v", n.Interfaces)
+		}
+	}
+
+	m.Network.StorageRangeSize = 12
+	_, err = ToTemplateArgs(m)
+	if err == nil {
+		t.Error("storage network must be large enough for racks")
+	}
+}
+
+func TestExternalPeers(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(1, []RackMenu{{CS: 1, Boot: 1}})
+	m.ExternalPeers = []*ExternalPeerMenu{
+		{Name: "isp1", ASN: 65001, Link: mustParseCIDR("10.0.5.0/30")},
+	}
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer := ta.ExternalPeers[0]
+	if peer.CoreAddress.String() != "10.0.5.1/30" || peer.Address.String() != "10.0.5.2/30" {
+		t.Errorf("unexpected peer addresses: %v", peer)
+	}
+
+	c, err := generateCluster(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, p := range c.pods {
+		if p.Name == "isp1" {
+			found = true
+			if p.Interfaces[0].Network != "core-to-isp1" {
+				t.Errorf("unexpected interfaces: %v", p.Interfaces)
+			}
+		}
+	}
+	if !found {
+		t.Error("pod for external peer is not generated")
+	}
+
+	m.ExternalPeers = append(m.ExternalPeers, &ExternalPeerMenu{Name: "isp1", ASN: 65002, Link: mustParseCIDR("10.0.5.4/30")})
+	_, err = ToTemplateArgs(m)
+	if err == nil {
+		t.Error("duplicate external peers must be rejected")
+	}
+
+	m.ExternalPeers = []*ExternalPeerMenu{{Name: "core", ASN: 65002, Link: mustParseCIDR("10.0.5.4/30")}}
+	ta, err = ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = generateCluster(ta)
+	if err == nil {
+		t.Error("external peer must not have the same name as other pods")
+	}
+}
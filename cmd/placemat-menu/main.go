@@ -4,14 +4,14 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
-	"text/template"
 
 	"github.com/cybozu-go/placemat-menu"
 	_ "github.com/cybozu-go/placemat-menu/cmd/placemat-menu/statik"
@@ -23,36 +23,38 @@ var staticFiles = []string{
 }
 
 var (
-	flagConfig = flag.String("f", "", "Template file for placemat-menu")
-	flagOutDir = flag.String("o", ".", "Directory for output files")
+	flagConfig     = flag.String("f", "", "Template file for placemat-menu")
+	flagOutDir     = flag.String("o", ".", "Directory for output files")
+	flagSecretsDir = flag.String("secrets", "", "Directory for secret files (default: <output dir>/secrets)")
+
+	flagTemplateDir = flag.String("template-dir", "", "Directory for templates overriding the embedded ones")
 )
 
 func main() {
 	flag.Parse()
-	err := run()
+	err := subMain()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
 
-func run() error {
+func subMain() error {
 	statikFS, err := fs.New()
 	if err != nil {
 		return err
 	}
 
-	opdir := filepath.Join(*flagOutDir, "operation")
-	err = os.MkdirAll(opdir, 0755)
-	if err != nil {
-		return err
-	}
-	sabakanDir := filepath.Join(*flagOutDir, "sabakan")
-	err = os.MkdirAll(sabakanDir, 0755)
-	if err != nil {
-		return err
+	switch flag.Arg(0) {
+	case "":
+		return run(statikFS)
+	case "templates":
+		return runTemplates(statikFS, flag.Args()[1:])
 	}
+	return errors.New("unknown command: " + flag.Arg(0))
+}
 
+func run(statikFS http.FileSystem) error {
 	f, err := os.Open(*flagConfig)
 	if err != nil {
 		return err
@@ -67,176 +69,285 @@ func run() error {
 		return err
 	}
 
-	clusterFile, err := os.Create(filepath.Join(*flagOutDir, "cluster.yml"))
+	templateDir := *flagTemplateDir
+	if templateDir == "" && m.Generator != nil {
+		templateDir = m.Generator.TemplateDir
+	}
+	routerConfigs, err := menu.RouterConfigs(ta)
 	if err != nil {
 		return err
 	}
-	defer clusterFile.Close()
-	err = menu.ExportCluster(clusterFile, ta)
+	templates, err := loadTemplates(newTemplateFS(statikFS, templateDir), ta, routerConfigs)
 	if err != nil {
 		return err
 	}
 
-	err = export(statikFS, "/templates/setup-default-gateway", "setup-default-gateway-operation", ta.Core.OperationAddress)
+	pluginFiles, err := menu.RunPlugins(m, ta)
 	if err != nil {
 		return err
 	}
-	err = export(statikFS, "/templates/setup-default-gateway", "setup-default-gateway-external", ta.Core.ExternalAddress)
+	cluster := new(bytes.Buffer)
+	networkNames, err := menu.ExportCluster(cluster, ta)
 	if err != nil {
 		return err
 	}
 
-	err = export(statikFS, "/templates/bird_core.conf", "bird_core.conf", ta)
+	out := new(outputSet)
+	out.mkdir("operation", 0755)
+	out.mkdir("sabakan", 0755)
+
+	err = out.add("cluster.yml", cluster.Bytes(), 0644)
 	if err != nil {
 		return err
 	}
-	for spineIdx := range ta.Spines {
-		err = export(statikFS, "/templates/bird_spine.conf",
-			fmt.Sprintf("bird_spine%d.conf", spineIdx+1),
-			menu.BIRDSpineTemplateArgs{Args: *ta, SpineIdx: spineIdx})
+
+	if ta.Network.ShortenNames {
+		names := new(bytes.Buffer)
+		err = menu.ExportNetworkNames(names, networkNames)
+		if err != nil {
+			return err
+		}
+		err = out.add("network-names.txt", names.Bytes(), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.Network.BGPAuth != nil {
+		err = exportBGPSecrets(out, ta)
 		if err != nil {
 			return err
 		}
 	}
 
-	networkFile, err := os.Create(filepath.Join(*flagOutDir, "network.yml"))
+	err = export(out, templates, "/templates/setup-default-gateway", "setup-default-gateway-operation", ta.Core.OperationAddress)
 	if err != nil {
 		return err
 	}
-	defer networkFile.Close()
-	err = menu.ExportEmptyNetworkConfig(networkFile)
+	err = export(out, templates, "/templates/setup-default-gateway", "setup-default-gateway-external", ta.Core.ExternalAddress)
 	if err != nil {
 		return err
 	}
 
+	for _, rc := range routerConfigs {
+		content, mode, err := templates.render(rc.Template, rc.Args)
+		if err != nil {
+			return err
+		}
+		if rc.Mode != 0 {
+			mode = rc.Mode
+		}
+		err = out.add(rc.File, content, mode)
+		if err != nil {
+			return err
+		}
+	}
+
+	nodeContexts := make(map[string]*menu.CloudInitContext)
 	for rackIdx, rack := range ta.Racks {
-		if ta.Boot.CloudInitTemplate != "" {
-			arg := struct {
-				Name string
-				Rack menu.Rack
-			}{
-				fmt.Sprintf("boot-%d", rack.Index),
-				rack,
+		for _, node := range rack.Nodes() {
+			ctx, err := menu.NewCloudInitContext(ta, rackIdx, node.Fullname)
+			if err != nil {
+				return err
 			}
-			err := exportFile(ta.Boot.CloudInitTemplate, fmt.Sprintf("seed_boot-%d.yml", rack.Index), arg)
+			nodeContexts[ctx.Name] = ctx
+			err = exportNodeConfig(out, templates, ta, rackIdx, ctx)
 			if err != nil {
 				return err
 			}
-		}
 
-		if ta.CS.CloudInitTemplate != "" {
-			for _, cs := range rack.CSList {
-				arg := struct {
-					Name string
-					Rack menu.Rack
-				}{
-					fmt.Sprintf("%s-%s", rack.Name, cs.Name),
-					rack,
+			if ctx.Resource.HasSeed() {
+				err = exportSeed(out, templates, fmt.Sprintf("seed_%s.yml", ctx.Name), ctx)
+				if err != nil {
+					return err
 				}
-				err := exportFile(ta.CS.CloudInitTemplate, fmt.Sprintf("seed_%s-%s.yml", rack.Name, cs.Name), arg)
+				err = exportMetaData(out, fmt.Sprintf("meta-data_%s.yml", ctx.Name), ctx.MetaData)
 				if err != nil {
 					return err
 				}
 			}
-		}
-
-		if ta.SS.CloudInitTemplate != "" {
-			for _, ss := range rack.SSList {
-				arg := struct {
-					Name string
-					Rack menu.Rack
-				}{
-					fmt.Sprintf("%s-%s", rack.Name, ss.Name),
-					rack,
+			if ctx.Resource.VendorDataTemplate != "" {
+				err = exportFile(out, templates, ctx.Resource.VendorDataTemplate, fmt.Sprintf("vendor-data_%s.yml", ctx.Name), ctx)
+				if err != nil {
+					return err
 				}
-				err := exportFile(ta.SS.CloudInitTemplate, fmt.Sprintf("seed_%s-%s.yml", rack.Name, ss.Name), arg)
+			}
+			if ctx.Resource.IgnitionTemplate != "" {
+				err = exportIgnition(out, templates, ctx.Resource.IgnitionTemplate, fmt.Sprintf("ignition_%s.ign", ctx.Name), ctx)
 				if err != nil {
 					return err
 				}
 			}
 		}
+	}
 
-		err = export(statikFS, "/templates/bird_rack-tor1.conf",
-			fmt.Sprintf("bird_rack%d-tor1.conf", rackIdx),
-			menu.BIRDRackTemplateArgs{Args: *ta, RackIdx: rackIdx})
+	for _, o := range ta.OutputFiles {
+		targets, err := ta.OutputTargets(o, nodeContexts)
 		if err != nil {
 			return err
 		}
+		for _, t := range targets {
+			err = exportFile(out, templates, o.Template, t.Path, t.Context)
+			if err != nil {
+				return err
+			}
+		}
+	}
 
-		err = export(statikFS, "/templates/bird_rack-tor2.conf",
-			fmt.Sprintf("bird_rack%d-tor2.conf", rackIdx),
-			menu.BIRDRackTemplateArgs{Args: *ta, RackIdx: rackIdx})
+	sabakanFiles, err := menu.SabakanData(m, ta)
+	if err != nil {
+		return err
+	}
+	for _, sf := range sabakanFiles {
+		err = out.add(filepath.Join("sabakan", sf.Name), sf.Content, 0644)
 		if err != nil {
 			return err
 		}
 	}
 
-	err = menu.ExportSabakanData(sabakanDir, m, ta)
+	err = copyStatics(out, statikFS, staticFiles)
 	if err != nil {
 		return err
 	}
 
-	return copyStatics(statikFS, staticFiles, *flagOutDir)
+	// plugin files are added last so that they never replace built-in outputs
+	for _, pf := range pluginFiles {
+		err = exportPluginFile(out, pf)
+		if err != nil {
+			return err
+		}
+	}
+
+	return out.write()
 }
 
-func exportFile(input string, output string, args interface{}) error {
-	f, err := os.Create(filepath.Join(*flagOutDir, output))
+// exportNodeConfig exports bird_<node>.conf, networkd_<node> directory and
+// network_<node>.yml.  ctx.BIRD is set to the rendered BIRD configuration.
+func exportNodeConfig(out *outputSet, templates *templateSet, ta *menu.TemplateArgs, rackIdx int, ctx *menu.CloudInitContext) error {
+	bird, mode, err := templates.render("/templates/bird_node.conf", ta.NodeRouter(rackIdx, ctx.Node))
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	templateFile, err := os.Open(input)
+	if ta.RouterConfigMode() != 0 {
+		mode = ta.RouterConfigMode()
+	}
+	err = out.add(fmt.Sprintf("bird_%s.conf", ctx.Name), bird, mode)
 	if err != nil {
 		return err
 	}
-	content, err := ioutil.ReadAll(templateFile)
+	ctx.BIRD = string(bird)
+
+	dir := fmt.Sprintf("networkd_%s", ctx.Name)
+	out.mkdir(dir, 0755)
+	for _, f := range ctx.Networkd {
+		err = out.add(filepath.Join(dir, f.Name), []byte(f.Content), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	err = menu.ExportNetworkConfig(buf, ctx.NetworkConfig)
 	if err != nil {
 		return err
 	}
+	return out.add(fmt.Sprintf("network_%s.yml", ctx.Name), buf.Bytes(), 0644)
+}
+
+func exportBGPSecrets(out *outputSet, ta *menu.TemplateArgs) error {
+	dir := "secrets"
+	if *flagSecretsDir != "" {
+		// -secrets is relative to the current directory, not the output directory
+		var err error
+		dir, err = filepath.Abs(*flagSecretsDir)
+		if err != nil {
+			return err
+		}
+	}
+	out.mkdir(dir, 0700)
 
-	tmpl, err := template.New(input).Parse(string(content))
+	buf := new(bytes.Buffer)
+	err := menu.ExportBGPSecrets(buf, ta)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	return tmpl.Execute(f, args)
+	return out.add(filepath.Join(dir, "bgp-secrets.yml"), buf.Bytes(), 0600)
 }
 
-func export(fs http.FileSystem, input string, output string, args interface{}) error {
-	f, err := os.Create(filepath.Join(*flagOutDir, output))
+func exportPluginFile(out *outputSet, pf menu.PluginFile) error {
+	mode, err := pf.FileMode()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	err = out.addExclusive(pf.Path, []byte(pf.Content), mode)
+	if err != nil {
+		return fmt.Errorf("plugin output collides with built-in outputs: %v", err)
+	}
+	return nil
+}
 
-	templateFile, err := fs.Open(input)
+func exportFile(out *outputSet, templates *templateSet, input string, output string, args interface{}) error {
+	content, err := templates.renderFile(input, args)
 	if err != nil {
 		return err
 	}
-	fi, err := templateFile.Stat()
+	return out.add(output, content, 0644)
+}
+
+// exportSeed renders the cloud-init template of the node if any, and exports
+// it merged with the cloud-init settings in the menu
+func exportSeed(out *outputSet, templates *templateSet, output string, ctx *menu.CloudInitContext) error {
+	var content []byte
+	if ctx.Resource.CloudInitTemplate != "" {
+		var err error
+		content, err = templates.renderFile(ctx.Resource.CloudInitTemplate, ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if ctx.Resource.CloudInit != nil {
+		var err error
+		content, err = menu.MergeCloudConfig(content, ctx.Resource.CloudInit)
+		if err != nil {
+			return fmt.Errorf("failed to merge cloud-init of %s: %v", ctx.Name, err)
+		}
+	}
+	return out.add(output, content, 0644)
+}
+
+func exportMetaData(out *outputSet, output string, md *menu.MetaData) error {
+	buf := new(bytes.Buffer)
+	err := menu.ExportMetaData(buf, md)
 	if err != nil {
 		return err
 	}
-	content, err := ioutil.ReadAll(templateFile)
+	return out.add(output, buf.Bytes(), 0644)
+}
+
+// exportIgnition renders the Ignition template, and exports it as Ignition config
+func exportIgnition(out *outputSet, templates *templateSet, input string, output string, args interface{}) error {
+	content, err := templates.renderFile(input, args)
 	if err != nil {
 		return err
 	}
-
-	tmpl, err := template.New(input).Parse(string(content))
+	ign, err := menu.ConvertIgnition(content)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("invalid Ignition template %s: %v", input, err)
 	}
-	err = tmpl.Execute(f, args)
+	return out.add(output, ign, 0644)
+}
+
+func export(out *outputSet, templates *templateSet, input string, output string, args interface{}) error {
+	content, mode, err := templates.render(input, args)
 	if err != nil {
 		return err
 	}
-
-	return f.Chmod(fi.Mode())
+	return out.add(output, content, mode)
 }
 
-func copyStatics(fs http.FileSystem, inputs []string, outputDirName string) error {
+func copyStatics(out *outputSet, fs http.FileSystem, inputs []string) error {
 	for _, fileName := range inputs {
-		err := copyStatic(fs, fileName, outputDirName)
+		err := copyStatic(out, fs, fileName)
 		if err != nil {
 			return err
 		}
@@ -246,7 +357,7 @@ func copyStatics(fs http.FileSystem, inputs []string, outputDirName string) erro
 	return nil
 }
 
-func copyStatic(fs http.FileSystem, fileName string, outputDirName string) error {
+func copyStatic(out *outputSet, fs http.FileSystem, fileName string) error {
 	src, err := fs.Open(fileName)
 	if err != nil {
 		return err
@@ -257,17 +368,9 @@ func copyStatic(fs http.FileSystem, fileName string, outputDirName string) error
 		return err
 	}
 
-	dst, err := os.Create(filepath.Join(outputDirName, filepath.Base(fileName)))
+	content, err := ioutil.ReadAll(src)
 	if err != nil {
 		return err
 	}
-	defer dst.Close()
-
-	err = dst.Chmod(fi.Mode())
-	if err != nil {
-		return err
-	}
-
-	_, err = io.Copy(dst, src)
-	return err
+	return out.add(filepath.Base(fileName), content, fi.Mode())
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outputFile is a file to be written.  name is relative to the output
+// directory unless it is an absolute path.
+type outputFile struct {
+	name    string
+	content []byte
+	mode    os.FileMode
+}
+
+type outputDir struct {
+	name string
+	mode os.FileMode
+}
+
+// outputSet holds all the output files in memory.  Nothing is written until
+// all of them are generated, so that an error in a template or the menu does
+// not leave output files half generated.  Each file is replaced atomically,
+// but an I/O error in write may leave some files written and others not.
+type outputSet struct {
+	dirs  []outputDir
+	files []outputFile
+}
+
+// path returns the path of name in the output directory
+func (s *outputSet) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(*flagOutDir, name)
+}
+
+// mkdir registers the directory to be created with mode
+func (s *outputSet) mkdir(name string, mode os.FileMode) {
+	s.dirs = append(s.dirs, outputDir{name: s.path(name), mode: mode})
+}
+
+// add registers the file.  It returns an error if the file collides with a
+// file already registered, or a directory of it.  The same file can be added
+// again with the same content and mode, e.g. frr_daemons shared by routers.
+func (s *outputSet) add(name string, content []byte, mode os.FileMode) error {
+	return s.addFile(name, content, mode, true)
+}
+
+// addExclusive registers the file like add, but the file must not be added twice
+func (s *outputSet) addExclusive(name string, content []byte, mode os.FileMode) error {
+	return s.addFile(name, content, mode, false)
+}
+
+func (s *outputSet) addFile(name string, content []byte, mode os.FileMode, shared bool) error {
+	p := s.path(name)
+	for _, d := range s.dirs {
+		if d.name == p {
+			return fmt.Errorf("%s collides with directory %s", name, d.name)
+		}
+	}
+	for _, f := range s.files {
+		switch {
+		case f.name == p && shared && f.mode == mode && bytes.Equal(f.content, content):
+			return nil
+		case f.name == p:
+			return fmt.Errorf("%s is generated twice", name)
+		case strings.HasPrefix(f.name, p+string(filepath.Separator)):
+			return fmt.Errorf("%s collides with directory of %s", name, f.name)
+		case strings.HasPrefix(p, f.name+string(filepath.Separator)):
+			return fmt.Errorf("%s collides with file %s", name, f.name)
+		}
+	}
+	s.files = append(s.files, outputFile{name: p, content: content, mode: mode})
+	return nil
+}
+
+// write writes all the registered directories and files
+func (s *outputSet) write() error {
+	for _, d := range s.dirs {
+		err := os.MkdirAll(d.name, d.mode)
+		if err != nil {
+			return err
+		}
+		err = os.Chmod(d.name, d.mode)
+		if err != nil {
+			return err
+		}
+	}
+	for _, f := range s.files {
+		err := os.MkdirAll(filepath.Dir(f.name), 0755)
+		if err != nil {
+			return err
+		}
+		err = writeFile(f.name, f.content, f.mode)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
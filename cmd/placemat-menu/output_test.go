@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputSetAdd(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		setup func(s *outputSet) error
+		ok    bool
+	}{
+		{
+			name: "same content shared",
+			setup: func(s *outputSet) error {
+				s.add("/out/frr_daemons", []byte("a"), 0644)
+				return s.add("/out/frr_daemons", []byte("a"), 0644)
+			},
+			ok: true,
+		},
+		{
+			name: "different content",
+			setup: func(s *outputSet) error {
+				s.add("/out/frr_daemons", []byte("a"), 0644)
+				return s.add("/out/frr_daemons", []byte("b"), 0644)
+			},
+		},
+		{
+			name: "different mode",
+			setup: func(s *outputSet) error {
+				s.add("/out/frr_daemons", []byte("a"), 0644)
+				return s.add("/out/frr_daemons", []byte("a"), 0755)
+			},
+		},
+		{
+			name: "exclusive",
+			setup: func(s *outputSet) error {
+				s.addExclusive("/out/cluster.yml", []byte("a"), 0644)
+				return s.addExclusive("/out/cluster.yml", []byte("a"), 0644)
+			},
+		},
+		{
+			name: "exclusive after shared",
+			setup: func(s *outputSet) error {
+				s.add("/out/cluster.yml", []byte("a"), 0644)
+				return s.addExclusive("/out/cluster.yml", []byte("a"), 0644)
+			},
+		},
+		{
+			name: "directory of registered file",
+			setup: func(s *outputSet) error {
+				s.add("/out/extra/hosts", []byte("a"), 0644)
+				return s.add("/out/extra", []byte("a"), 0644)
+			},
+		},
+		{
+			name: "under registered file",
+			setup: func(s *outputSet) error {
+				s.add("/out/extra", []byte("a"), 0644)
+				return s.add("/out/extra/hosts", []byte("a"), 0644)
+			},
+		},
+		{
+			name: "registered directory",
+			setup: func(s *outputSet) error {
+				s.mkdir("/out/bird", 0755)
+				return s.add("/out/bird", []byte("a"), 0644)
+			},
+		},
+		{
+			name: "similar names",
+			setup: func(s *outputSet) error {
+				s.add("/out/extra", []byte("a"), 0644)
+				return s.add("/out/extra2/hosts", []byte("a"), 0644)
+			},
+			ok: true,
+		},
+	}
+	for _, c := range cases {
+		s := new(outputSet)
+		err := c.setup(s)
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s: error should be returned", c.name)
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "placemat-menu-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "setup-iptables")
+	for _, c := range []struct {
+		content string
+		mode    os.FileMode
+	}{
+		{"old", 0644},
+		{"new", 0755},
+	} {
+		err = writeFile(p, []byte(c.content), c.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.content {
+			t.Errorf("wrong content: expected=%q, actual=%q", c.content, string(data))
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != c.mode {
+			t.Errorf("wrong mode: expected=%v, actual=%v", c.mode, fi.Mode().Perm())
+		}
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 {
+		t.Error("temporary files should not be left:", len(fis))
+	}
+
+	err = writeFile(filepath.Join(dir, "missing", "file"), []byte("a"), 0644)
+	if err == nil {
+		t.Error("writeFile should fail without the directory")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/cybozu-go/placemat-menu"
+)
+
+type parsedTemplate struct {
+	tmpl *template.Template
+	mode os.FileMode
+}
+
+// templateSet holds the templates parsed before generating any files, so that
+// errors in templates are reported without leaving output files half generated.
+type templateSet struct {
+	fs       http.FileSystem
+	embedded map[string]parsedTemplate
+	files    map[string]parsedTemplate
+}
+
+func newTemplateSet(fs http.FileSystem) *templateSet {
+	return &templateSet{
+		fs:       fs,
+		embedded: make(map[string]parsedTemplate),
+		files:    make(map[string]parsedTemplate),
+	}
+}
+
+// loadTemplates parses all the templates used to generate files for ta
+func loadTemplates(fs http.FileSystem, ta *menu.TemplateArgs, routerConfigs []menu.RouterConfig) (*templateSet, error) {
+	s := newTemplateSet(fs)
+
+	embedded := []string{"/templates/setup-default-gateway", "/templates/bird_node.conf"}
+	for _, rc := range routerConfigs {
+		embedded = append(embedded, rc.Template)
+	}
+	for _, name := range embedded {
+		err := s.load(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var files []string
+	for _, r := range []menu.VMResource{ta.Boot, ta.CS, ta.SS} {
+		files = append(files, r.CloudInitTemplate, r.IgnitionTemplate, r.VendorDataTemplate)
+	}
+	for _, o := range ta.OutputFiles {
+		files = append(files, o.Template)
+	}
+	for _, name := range files {
+		if name == "" {
+			continue
+		}
+		err := s.loadFile(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// load parses the template in the template file system
+func (s *templateSet) load(name string) error {
+	if _, ok := s.embedded[name]; ok {
+		return nil
+	}
+	f, err := s.fs.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := menu.ParseTemplate(templatePath(s.fs, name), string(content))
+	if err != nil {
+		return err
+	}
+	s.embedded[name] = parsedTemplate{tmpl: tmpl, mode: fi.Mode()}
+	return nil
+}
+
+// loadFile parses the template file given in the menu
+func (s *templateSet) loadFile(name string) error {
+	if _, ok := s.files[name]; ok {
+		return nil
+	}
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := menu.ParseTemplate(name, string(content))
+	if err != nil {
+		return err
+	}
+	s.files[name] = parsedTemplate{tmpl: tmpl, mode: 0644}
+	return nil
+}
+
+// render renders the template in the template file system, and returns the
+// result and the mode of the template file
+func (s *templateSet) render(name string, args interface{}) ([]byte, os.FileMode, error) {
+	err := s.load(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	t := s.embedded[name]
+	content, err := menu.ExecuteTemplate(t.tmpl, args)
+	if err != nil {
+		return nil, 0, err
+	}
+	return content, t.mode, nil
+}
+
+// renderFile renders the template file given in the menu
+func (s *templateSet) renderFile(name string, args interface{}) ([]byte, error) {
+	err := s.loadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return menu.ExecuteTemplate(s.files[name].tmpl, args)
+}
+
+// writeFile writes content to the file atomically.  The content is written to
+// a temporary file in the same directory, and then renamed to name.
+func writeFile(name string, content []byte, mode os.FileMode) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	_, err = f.Write(content)
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(mode)
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
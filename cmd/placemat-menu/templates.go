@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const templatesDir = "/templates"
+
+// templateFS is an http.FileSystem which looks up templates in dir before
+// the embedded ones.  Files other than templates are always embedded ones.
+type templateFS struct {
+	base http.FileSystem
+	dir  string
+}
+
+func newTemplateFS(base http.FileSystem, dir string) http.FileSystem {
+	if dir == "" {
+		return base
+	}
+	return templateFS{base: base, dir: dir}
+}
+
+func (t templateFS) Open(name string) (http.File, error) {
+	if path.Dir(name) == templatesDir {
+		f, err := http.Dir(t.dir).Open(path.Base(name))
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return t.base.Open(name)
+}
+
+// templatePath returns the path of the template file to be shown in messages.
+// It is the path in the template directory if the template is overridden.
+func templatePath(fs http.FileSystem, name string) string {
+	t, ok := fs.(templateFS)
+	if !ok || path.Dir(name) != templatesDir {
+		return name
+	}
+	p := filepath.Join(t.dir, path.Base(name))
+	if _, err := os.Stat(p); err != nil {
+		return name
+	}
+	return p
+}
+
+func runTemplates(fs http.FileSystem, args []string) error {
+	if len(args) != 2 || args[0] != "dump" {
+		return errors.New("usage: placemat-menu templates dump DIR")
+	}
+	return dumpTemplates(fs, args[1])
+}
+
+// dumpTemplates extracts the embedded templates into dir.  Existing files are
+// not overwritten; if any of the templates exists in dir, it fails before
+// writing any files.
+func dumpTemplates(fs http.FileSystem, dir string) error {
+	d, err := fs.Open(templatesDir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	fis, err := d.Readdir(-1)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, fi := range fis {
+		if fi.IsDir() || strings.Contains(fi.Name(), "/") {
+			continue
+		}
+		p := filepath.Join(dir, fi.Name())
+		_, err := os.Lstat(p)
+		if err == nil {
+			return fmt.Errorf("%s already exists", p)
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		names = append(names, fi.Name())
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		err = dumpTemplate(fs, name, dir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dumpTemplate(fs http.FileSystem, name, dir string) error {
+	src, err := fs.Open(path.Join(templatesDir, name))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode())
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
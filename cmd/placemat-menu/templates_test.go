@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, p, content string) {
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(p, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, fs http.FileSystem, name string) string {
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// testBaseFS returns the file system which stands for the embedded files
+func testBaseFS(t *testing.T, dir string) http.FileSystem {
+	writeTestFile(t, filepath.Join(dir, "templates", "bird_core.conf"), "embedded core")
+	writeTestFile(t, filepath.Join(dir, "templates", "bird_node.conf"), "embedded node")
+	writeTestFile(t, filepath.Join(dir, "static", "bird_core.conf"), "static")
+	return http.Dir(dir)
+}
+
+func TestTemplateFS(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "placemat-menu-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := testBaseFS(t, filepath.Join(dir, "base"))
+	if fs := newTemplateFS(base, ""); fs != base {
+		t.Error("newTemplateFS should return the base file system without the template directory")
+	}
+
+	tmplDir := filepath.Join(dir, "override")
+	writeTestFile(t, filepath.Join(tmplDir, "bird_core.conf"), "override core")
+	writeTestFile(t, filepath.Join(tmplDir, "static"), "override static")
+	fs := newTemplateFS(base, tmplDir)
+
+	cases := []struct {
+		name    string
+		content string
+		path    string
+	}{
+		{"/templates/bird_core.conf", "override core", filepath.Join(tmplDir, "bird_core.conf")},
+		{"/templates/bird_node.conf", "embedded node", "/templates/bird_node.conf"},
+		{"/static/bird_core.conf", "static", "/static/bird_core.conf"},
+	}
+	for _, c := range cases {
+		content := readTestFile(t, fs, c.name)
+		if content != c.content {
+			t.Errorf("wrong content of %s: expected=%q, actual=%q", c.name, c.content, content)
+		}
+		p := templatePath(fs, c.name)
+		if p != c.path {
+			t.Errorf("wrong path of %s: expected=%s, actual=%s", c.name, c.path, p)
+		}
+	}
+
+	_, err = fs.Open("/templates/missing")
+	if !os.IsNotExist(err) {
+		t.Error("missing template should not exist:", err)
+	}
+}
+
+func TestDumpTemplates(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "placemat-menu-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := testBaseFS(t, filepath.Join(dir, "base"))
+
+	dumpDir := filepath.Join(dir, "dump", "templates")
+	err = dumpTemplates(base, dumpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := http.Dir(dumpDir)
+	if content := readTestFile(t, fs, "bird_core.conf"); content != "embedded core" {
+		t.Error("wrong content of bird_core.conf:", content)
+	}
+	if content := readTestFile(t, fs, "bird_node.conf"); content != "embedded node" {
+		t.Error("wrong content of bird_node.conf:", content)
+	}
+
+	partialDir := filepath.Join(dir, "partial")
+	writeTestFile(t, filepath.Join(partialDir, "bird_node.conf"), "modified node")
+	err = dumpTemplates(base, partialDir)
+	if err == nil {
+		t.Error("dumpTemplates should fail if a template exists")
+	}
+	if content := readTestFile(t, http.Dir(partialDir), "bird_node.conf"); content != "modified node" {
+		t.Error("existing template should not be overwritten:", content)
+	}
+	_, err = os.Stat(filepath.Join(partialDir, "bird_core.conf"))
+	if !os.IsNotExist(err) {
+		t.Error("no templates should be written if a template exists:", err)
+	}
+
+	err = runTemplates(base, []string{"dump"})
+	if err == nil {
+		t.Error("runTemplates should fail without DIR")
+	}
+}
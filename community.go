@@ -0,0 +1,125 @@
+package menu
+
+import (
+	"fmt"
+	"strings"
+)
+
+const maxCommunityValue = 65535
+
+// CommunityTag is a BGP community set on routes matching Set.
+// Set is a BIRD set of prefixes or addresses.
+type CommunityTag struct {
+	Name      string
+	Community string
+	Set       string
+}
+
+func validateCommunities(c *CommunitiesMenu, numRack int) error {
+	if c == nil {
+		return nil
+	}
+	if c.ASN < 0 || c.ASN > maxCommunityValue {
+		return fmt.Errorf("asn of communities must be between 0 and %d", maxCommunityValue)
+	}
+	values := map[string]int{
+		"rack-base":    c.RackBase,
+		"boot":         c.Boot,
+		"cs":           c.CS,
+		"ss":           c.SS,
+		"bastion":      c.Bastion,
+		"loadbalancer": c.LoadBalancer,
+		"ingress":      c.Ingress,
+		"global":       c.Global,
+	}
+	for name, v := range values {
+		if v < 0 || v > maxCommunityValue {
+			return fmt.Errorf("community value of %s must be between 0 and %d", name, maxCommunityValue)
+		}
+	}
+	if c.RackBase+numRack-1 > maxCommunityValue {
+		return fmt.Errorf("community values of racks exceed %d", maxCommunityValue)
+	}
+	return nil
+}
+
+func (t TemplateArgs) community(value int) string {
+	return fmt.Sprintf("(%d, %d)", t.Network.RoutingPolicy.Communities.ASN, value)
+}
+
+// RackCommunity returns the community of routes from the rack
+func (t TemplateArgs) RackCommunity(rackIdx int) string {
+	return t.community(t.Network.RoutingPolicy.Communities.RackBase + rackIdx)
+}
+
+// NodeTypeCommunities returns the communities of routes from each type of nodes in the rack.
+// The set of a community is the addresses of the nodes.
+func (t TemplateArgs) NodeTypeCommunities(rackIdx int) []CommunityTag {
+	c := t.Network.RoutingPolicy.Communities
+	rack := t.Racks[rackIdx]
+
+	var boots []Node
+	for _, boot := range rack.BootNodes {
+		boots = append(boots, boot.Node)
+	}
+
+	var tags []CommunityTag
+	for _, nodeType := range []struct {
+		name  string
+		value int
+		nodes []Node
+	}{
+		{"boot", c.Boot, boots},
+		{"cs", c.CS, rack.CSList},
+		{"ss", c.SS, rack.SSList},
+	} {
+		var addresses []string
+		for _, node := range nodeType.nodes {
+			addresses = append(addresses, node.Node1Address.IP.String())
+			if node.Node2Address != nil {
+				addresses = append(addresses, node.Node2Address.IP.String())
+			}
+		}
+		if len(addresses) == 0 {
+			continue
+		}
+		tags = append(tags, CommunityTag{
+			Name:      nodeType.name,
+			Community: t.community(nodeType.value),
+			Set:       strings.Join(addresses, ", "),
+		})
+	}
+	return tags
+}
+
+// ExposedCommunities returns the communities of routes in each exposed network
+func (t TemplateArgs) ExposedCommunities() []CommunityTag {
+	c := t.Network.RoutingPolicy.Communities
+	exposed := t.Network.Exposed
+	return []CommunityTag{
+		{Name: "bastion", Community: t.community(c.Bastion), Set: exposed.Bastion.String() + "+"},
+		{Name: "loadbalancer", Community: t.community(c.LoadBalancer), Set: exposed.LoadBalancer.String() + "+"},
+		{Name: "ingress", Community: t.community(c.Ingress), Set: exposed.Ingress.String() + "+"},
+		{Name: "global", Community: t.community(c.Global), Set: exposed.Global.String() + "+"},
+	}
+}
+
+// CommunityMatchers returns all communities with their names to define
+// functions matching them
+func (t TemplateArgs) CommunityMatchers() []CommunityTag {
+	c := t.Network.RoutingPolicy.Communities
+
+	var tags []CommunityTag
+	for _, rack := range t.Racks {
+		tags = append(tags, CommunityTag{Name: rack.Name, Community: t.RackCommunity(rack.Index)})
+	}
+	tags = append(tags,
+		CommunityTag{Name: "boot", Community: t.community(c.Boot)},
+		CommunityTag{Name: "cs", Community: t.community(c.CS)},
+		CommunityTag{Name: "ss", Community: t.community(c.SS)},
+	)
+	for _, tag := range t.ExposedCommunities() {
+		tags = append(tags, CommunityTag{Name: tag.Name, Community: tag.Community})
+	}
+	return tags
+}
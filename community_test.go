@@ -0,0 +1,48 @@
+package menu
+
+import (
+	"testing"
+)
+
+func TestCommunities(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(1, []RackMenu{{CS: 2, Boot: 1}, {SS: 1, Boot: 1}})
+	m.Network.Bastion = mustParseCIDR("10.72.48.0/26")
+	m.Network.LoadBalancer = mustParseCIDR("10.72.32.0/20")
+	m.Network.Ingress = mustParseCIDR("10.72.48.64/26")
+	m.Network.Global = mustParseCIDR("172.17.0.0/24")
+	communities := defaultCommunities
+	communities.ASN = 64600
+	m.Network.RoutingPolicy.Communities = &communities
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := ta.RackCommunity(1); c != "(64600, 1001)" {
+		t.Errorf("unexpected community of rack1: %s", c)
+	}
+
+	tags := ta.NodeTypeCommunities(0)
+	if len(tags) != 2 {
+		t.Fatalf("rack0 must have boot and cs communities: %v", tags)
+	}
+	if tags[1].Name != "cs" || tags[1].Community != "(64600, 2)" {
+		t.Errorf("unexpected community of cs: %v", tags[1])
+	}
+	if tags[1].Set != "10.69.0.68, 10.69.0.132, 10.69.0.69, 10.69.0.133" {
+		t.Errorf("unexpected addresses of cs: %s", tags[1].Set)
+	}
+
+	matchers := ta.CommunityMatchers()
+	if len(matchers) != 9 || matchers[0].Name != "rack0" || matchers[8].Name != "global" {
+		t.Errorf("unexpected matchers: %v", matchers)
+	}
+
+	communities.RackBase = 65535
+	_, err = ToTemplateArgs(m)
+	if err == nil {
+		t.Error("community values of racks must not exceed 65535")
+	}
+}
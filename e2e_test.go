@@ -40,14 +40,24 @@ func TestE2E(t *testing.T) {
 		"bird_rack0-tor2.conf",
 		"bird_rack1-tor1.conf",
 		"bird_rack1-tor2.conf",
+		"bird_rack0-cs1.conf",
+		"networkd_rack0-cs1/10-node0.netdev",
+		"networkd_rack0-cs1/10-node0.network",
+		"networkd_rack0-cs1/30-eth0.network",
+		"networkd_rack0-cs1/30-eth1.network",
+		"network_boot-0.yml",
+		"network_rack0-cs1.yml",
 		"seed_boot-0.yml",
 		"seed_boot-1.yml",
+		"seed_rack0-cs1.yml",
+		"meta-data_boot-0.yml",
+		"meta-data_rack0-cs1.yml",
 		"sabakan/ipam.json",
 		"sabakan/dhcp.json",
 		"sabakan/machines.json",
 	}
 
-	cmd := exec.Command("go", "run", "cmd/placemat-menu/main.go", "-f", "example.yml", "-o", dir)
+	cmd := exec.Command("go", "run", "./cmd/placemat-menu", "-f", "example.yml", "-o", dir)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	err = cmd.Run()
@@ -0,0 +1,129 @@
+package menu
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+func validateRouteFilter(filter *RouteFilterMenu, numRack int) error {
+	if filter == nil {
+		return nil
+	}
+	for _, idx := range filter.ExposedRacks {
+		if idx < 0 || idx >= numRack {
+			return fmt.Errorf("rack %d in exposed-racks does not exist", idx)
+		}
+	}
+	return nil
+}
+
+// exposedAllowed returns true if nodes in the rack may announce exposed addresses
+func (t TemplateArgs) exposedAllowed(rackIdx int) bool {
+	filter := t.Network.RoutingPolicy.Filter
+	if filter.ExposedRacks == nil {
+		return true
+	}
+	for _, idx := range filter.ExposedRacks {
+		if idx == rackIdx {
+			return true
+		}
+	}
+	return false
+}
+
+func (t TemplateArgs) exposedPrefixes() []string {
+	exposed := t.Network.Exposed
+	var prefixes []string
+	for _, n := range []*net.IPNet{exposed.Bastion, exposed.LoadBalancer, exposed.Ingress, exposed.Global} {
+		prefixes = append(prefixes, n.String()+"+")
+	}
+	return prefixes
+}
+
+func (t TemplateArgs) nodePrefixes(rackIdx int) []string {
+	var prefixes []string
+	rack := t.Racks[rackIdx]
+	prefixes = append(prefixes, rack.node0Network.String()+"{32,32}")
+	if t.exposedAllowed(rackIdx) {
+		prefixes = append(prefixes, t.exposedPrefixes()...)
+	}
+	for _, n := range t.Network.RoutingPolicy.Filter.NodePrefixes {
+		prefixes = append(prefixes, n.String()+"+")
+	}
+	return prefixes
+}
+
+func (t TemplateArgs) rackPrefixes(rackIdx int) []string {
+	rack := t.Racks[rackIdx]
+	prefixes := []string{rack.node1Network.String()}
+	if rack.node2Network != nil {
+		prefixes = append(prefixes, rack.node2Network.String())
+	}
+	return append(prefixes, t.nodePrefixes(rackIdx)...)
+}
+
+// NodeImportPrefixes returns the prefix set of routes that ToR switches
+// accept from nodes in the rack
+func (t TemplateArgs) NodeImportPrefixes(rackIdx int) string {
+	return strings.Join(t.nodePrefixes(rackIdx), ", ")
+}
+
+// RackImportPrefixes returns the prefix set of routes that spine switches
+// accept from ToR switches in the rack
+func (t TemplateArgs) RackImportPrefixes(rackIdx int) string {
+	return strings.Join(t.rackPrefixes(rackIdx), ", ")
+}
+
+func (t TemplateArgs) corePrefixes() []string {
+	var prefixes []string
+	seen := make(map[string]bool)
+	for rackIdx := range t.Racks {
+		for _, p := range t.rackPrefixes(rackIdx) {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
+}
+
+// CoreImportPrefixes returns the prefix set of routes that the core router
+// accepts from spine switches
+func (t TemplateArgs) CoreImportPrefixes() string {
+	return strings.Join(t.corePrefixes(), ", ")
+}
+
+// ToRImportPrefixes returns the prefix set of routes that ToR switches accept
+// from spine switches, i.e. routes of all racks, BMC networks of racks and
+// prefixes of external peers.  The default route is not included; it is
+// accepted only if the core router originates it.
+func (t TemplateArgs) ToRImportPrefixes() string {
+	prefixes := t.corePrefixes()
+	for _, rack := range t.Racks {
+		if rack.BMCGatewayAddress != nil {
+			prefixes = append(prefixes, rack.BMCNetwork.String())
+		}
+	}
+	for _, peer := range t.ExternalPeers {
+		for _, n := range peer.Prefixes {
+			prefixes = append(prefixes, n.String())
+		}
+	}
+	return strings.Join(prefixes, ", ")
+}
+
+// StorageImportPrefixes returns the prefix set of routes that the storage ToR
+// switch in the rack accepts from those in other racks
+func (t TemplateArgs) StorageImportPrefixes(rackIdx int) string {
+	var prefixes []string
+	for _, rack := range t.Racks {
+		if rack.Index == rackIdx || rack.StorageToR == nil {
+			continue
+		}
+		prefixes = append(prefixes, rack.StorageToR.NodeNetwork.String())
+	}
+	return strings.Join(prefixes, ", ")
+}
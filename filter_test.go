@@ -0,0 +1,149 @@
+package menu
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRouteFilter(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(1, []RackMenu{{CS: 1, Boot: 1}, {CS: 1, Boot: 1}})
+	m.Network.Bastion = mustParseCIDR("10.72.48.0/26")
+	m.Network.LoadBalancer = mustParseCIDR("10.72.32.0/20")
+	m.Network.Ingress = mustParseCIDR("10.72.48.64/26")
+	m.Network.Global = mustParseCIDR("172.17.0.0/24")
+	m.Network.RoutingPolicy.Filter = &RouteFilterMenu{
+		ExposedRacks: []int{1},
+		NodePrefixes: []*net.IPNet{mustParseCIDR("10.64.0.0/14")},
+	}
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "10.69.0.0/26{32,32}, 10.64.0.0/14+"
+	if p := ta.NodeImportPrefixes(0); p != expected {
+		t.Errorf("unexpected prefixes of rack0: %s != %s", p, expected)
+	}
+	expected = "10.69.0.192/26{32,32}, 10.72.48.0/26+, 10.72.32.0/20+, 10.72.48.64/26+, 172.17.0.0/24+, 10.64.0.0/14+"
+	if p := ta.NodeImportPrefixes(1); p != expected {
+		t.Errorf("unexpected prefixes of rack1: %s != %s", p, expected)
+	}
+	expected = "10.69.1.0/26, 10.69.1.64/26, " + expected
+	if p := ta.RackImportPrefixes(1); p != expected {
+		t.Errorf("unexpected prefixes from rack1: %s != %s", p, expected)
+	}
+	expected = "10.69.0.64/26, 10.69.0.128/26, 10.69.0.0/26{32,32}, 10.64.0.0/14+, 10.69.1.0/26, 10.69.1.64/26, 10.69.0.192/26{32,32}, 10.72.48.0/26+, 10.72.32.0/20+, 10.72.48.64/26+, 172.17.0.0/24+"
+	if p := ta.CoreImportPrefixes(); p != expected {
+		t.Errorf("unexpected prefixes from spines: %s != %s", p, expected)
+	}
+
+	m.Network.RoutingPolicy.Filter.ExposedRacks = []int{2}
+	_, err = ToTemplateArgs(m)
+	if err == nil {
+		t.Error("exposed-racks must be existing racks")
+	}
+}
+
+// prefixSetMatch returns true if prefix matches the BIRD prefix set.
+// Only the patterns generated by menu are supported: "P", "P+" and "P{L,H}".
+func prefixSetMatch(t *testing.T, set string, prefix *net.IPNet) bool {
+	ones, _ := prefix.Mask.Size()
+	for _, item := range strings.Split(set, ", ") {
+		low, high := -1, -1
+		switch {
+		case strings.HasSuffix(item, "+"):
+			item = strings.TrimSuffix(item, "+")
+			high = 32
+		case strings.HasSuffix(item, "}"):
+			i := strings.Index(item, "{")
+			bounds := strings.Split(item[i+1:len(item)-1], ",")
+			low, _ = strconv.Atoi(bounds[0])
+			high, _ = strconv.Atoi(bounds[1])
+			item = item[:i]
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			t.Fatalf("invalid prefix set %s: %v", set, err)
+		}
+		length, _ := n.Mask.Size()
+		if low < 0 {
+			low = length
+		}
+		if high < 0 {
+			high = length
+		}
+		if ones >= length && ones >= low && ones <= high && n.Contains(prefix.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterAccepts returns true if the route filter accepts the route of prefix
+// originated from the AS
+func filterAccepts(t *testing.T, f *RouteFilter, prefix *net.IPNet, origin int) bool {
+	if ones, _ := prefix.Mask.Size(); ones == 0 && f.DefaultOrigin != 0 {
+		return origin == f.DefaultOrigin
+	}
+	return f.Prefixes == "" || prefixSetMatch(t, f.Prefixes, prefix)
+}
+
+func TestToRImportFilter(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, SS: 1, Boot: 1}, {CS: 1, SS: 1, Boot: 1}})
+	m.Network.RoutingPolicy.Filter = &RouteFilterMenu{}
+	m.Network.Storage = mustParseCIDR("10.70.0.0/20")
+	m.Network.StorageRangeSize = 6
+	m.Network.StorageBackbone = mustParseCIDR("10.70.255.0/24")
+	m.ExternalPeers = []*ExternalPeerMenu{
+		{Name: "isp1", ASN: 65001, Link: mustParseCIDR("10.0.5.0/30"), Prefixes: []*net.IPNet{mustParseCIDR("192.0.2.0/24")}},
+	}
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tor := ta.ToRRouter(0, 1)
+	f := tor.Filter(tor.Group(GroupSpine).ImportFilter)
+	if f == nil {
+		t.Fatal("ToR switches must filter routes from spines")
+	}
+	defaultRoute := mustParseCIDR("0.0.0.0/0")
+	rack1 := ta.Racks[1]
+	cases := []struct {
+		name   string
+		prefix *net.IPNet
+		origin int
+		accept bool
+	}{
+		{"default from core", defaultRoute, ta.Network.ASNCore, true},
+		{"default leaked from rack1", defaultRoute, rack1.ASN, false},
+		{"default leaked from isp1", defaultRoute, 65001, false},
+		{"node0 of rack1", rack1.Nodes()[0].Node0Address, rack1.ASN, true},
+		{"node network of rack1", rack1.node1Network, rack1.ASN, true},
+		{"prefix of isp1", mustParseCIDR("192.0.2.0/24"), 65001, true},
+		{"unknown prefix", mustParseCIDR("198.51.100.0/24"), rack1.ASN, false},
+	}
+	for _, c := range cases {
+		if filterAccepts(t, f, c.prefix, c.origin) != c.accept {
+			t.Errorf("%s: accept must be %v", c.name, c.accept)
+		}
+	}
+
+	stor := ta.StorageToRRouter(0)
+	f = stor.Filter(stor.Group(GroupStorage).ImportFilter)
+	if f == nil {
+		t.Fatal("storage ToR switches must filter routes from other racks")
+	}
+	if !filterAccepts(t, f, rack1.StorageToR.NodeNetwork, rack1.ASN) {
+		t.Error("storage network of rack1 must be accepted")
+	}
+	if filterAccepts(t, f, ta.Racks[0].StorageToR.NodeNetwork, rack1.ASN) || filterAccepts(t, f, defaultRoute, rack1.ASN) {
+		t.Error("storage ToR switches must accept only storage networks of other racks")
+	}
+}
@@ -0,0 +1,183 @@
+package menu
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/cybozu-go/netutil"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// FuncMap returns the functions available in the templates of placemat-menu.
+//
+//   - ipAdd ADDR N: ADDR plus N.  ADDR is an IP address, a CIDR or a *net.IPNet.
+//     The result keeps the prefix length if ADDR has one.
+//   - cidrHost NETWORK N: The N-th address in NETWORK.  Negative N counts from
+//     the end, so -1 is the broadcast address.
+//   - cidrNetmask NETWORK: The netmask of NETWORK as a dotted quad.
+//   - prefixLen NETWORK: The prefix length of NETWORK.
+//   - toJSON VALUE, toYAML VALUE: VALUE encoded in JSON or YAML.
+//   - indent N STRING: STRING with each line indented by N spaces.
+//   - join SEP LIST: The elements of LIST joined with SEP.
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"ipAdd":       ipAdd,
+		"cidrHost":    cidrHost,
+		"cidrNetmask": cidrNetmask,
+		"prefixLen":   prefixLen,
+		"toJSON":      toJSON,
+		"toYAML":      toYAML,
+		"indent":      indent,
+		"join":        join,
+	}
+}
+
+func toIPNet(v interface{}) (*net.IPNet, error) {
+	switch a := v.(type) {
+	case *net.IPNet:
+		if a == nil {
+			return nil, fmt.Errorf("nil network")
+		}
+		return a, nil
+	case net.IPNet:
+		return &a, nil
+	case string:
+		ip, n, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, err
+		}
+		n.IP = ip
+		return n, nil
+	}
+	return nil, fmt.Errorf("not a network: %v", v)
+}
+
+func toIPv4(v interface{}) (net.IP, error) {
+	var ip net.IP
+	switch a := v.(type) {
+	case net.IP:
+		ip = a
+	case string:
+		if n, err := toIPNet(a); err == nil {
+			ip = n.IP
+		} else {
+			ip = net.ParseIP(a)
+		}
+	default:
+		n, err := toIPNet(v)
+		if err != nil {
+			return nil, fmt.Errorf("not an IP address: %v", v)
+		}
+		ip = n.IP
+	}
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil, fmt.Errorf("not an IPv4 address: %v", v)
+	}
+	return ip4, nil
+}
+
+func ipAdd(v interface{}, n int) (interface{}, error) {
+	ip, err := toIPv4(v)
+	if err != nil {
+		return nil, err
+	}
+	result := netutil.IntToIP4(netutil.IP4ToInt(ip) + uint32(n))
+
+	switch a := v.(type) {
+	case net.IP:
+		return result, nil
+	case string:
+		if ipNet, err := toIPNet(a); err == nil {
+			return (&net.IPNet{IP: result, Mask: ipNet.Mask}).String(), nil
+		}
+		return result.String(), nil
+	}
+	ipNet, _ := toIPNet(v)
+	return &net.IPNet{IP: result, Mask: ipNet.Mask}, nil
+}
+
+func cidrHost(v interface{}, n int) (net.IP, error) {
+	ipNet, err := toIPNet(v)
+	if err != nil {
+		return nil, err
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits != 32 {
+		return nil, fmt.Errorf("not an IPv4 network: %v", ipNet)
+	}
+	size := int64(1) << uint(bits-ones)
+	idx := int64(n)
+	if idx < 0 {
+		idx += size
+	}
+	if idx < 0 || idx >= size {
+		return nil, fmt.Errorf("host %d is out of %v", n, ipNet)
+	}
+	base := netutil.IP4ToInt(ipNet.IP.Mask(ipNet.Mask))
+	return netutil.IntToIP4(base + uint32(idx)), nil
+}
+
+func cidrNetmask(v interface{}) (string, error) {
+	ipNet, err := toIPNet(v)
+	if err != nil {
+		return "", err
+	}
+	if len(ipNet.Mask) != net.IPv4len {
+		return "", fmt.Errorf("not an IPv4 network: %v", ipNet)
+	}
+	return net.IP(ipNet.Mask).String(), nil
+}
+
+func prefixLen(v interface{}) (int, error) {
+	ipNet, err := toIPNet(v)
+	if err != nil {
+		return 0, err
+	}
+	ones, _ := ipNet.Mask.Size()
+	return ones, nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func toYAML(v interface{}) (string, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+func join(sep string, list interface{}) (string, error) {
+	switch a := list.(type) {
+	case []string:
+		return strings.Join(a, sep), nil
+	case nil:
+		return "", nil
+	}
+
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return "", fmt.Errorf("not a list: %v", list)
+	}
+	elems := make([]string, rv.Len())
+	for i := range elems {
+		elems[i] = fmt.Sprint(rv.Index(i).Interface())
+	}
+	return strings.Join(elems, sep), nil
+}
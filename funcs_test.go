@@ -0,0 +1,71 @@
+package menu
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"text/template"
+)
+
+func TestFuncMap(t *testing.T) {
+	t.Parallel()
+
+	args := struct {
+		Network *net.IPNet
+		IP      net.IP
+		List    []string
+		Ints    []int
+		Map     map[string]int
+	}{
+		Network: mustParseCIDR("10.69.0.64/26"),
+		IP:      net.ParseIP("10.0.0.1"),
+		List:    []string{"a", "b"},
+		Ints:    []int{1, 2},
+		Map:     map[string]int{"x": 1},
+	}
+
+	cases := []struct {
+		tmpl     string
+		expected string
+	}{
+		{`{{ipAdd .Network 3}}`, "10.69.0.67/26"},
+		{`{{ipAdd .IP 1}}`, "10.0.0.2"},
+		{`{{ipAdd "10.0.0.255" 1}}`, "10.0.1.0"},
+		{`{{ipAdd "10.0.0.1/24" -1}}`, "10.0.0.0/24"},
+		{`{{cidrHost .Network 1}}`, "10.69.0.65"},
+		{`{{cidrHost .Network -1}}`, "10.69.0.127"},
+		{`{{cidrHost "10.0.0.5/24" 10}}`, "10.0.0.10"},
+		{`{{cidrNetmask .Network}}`, "255.255.255.192"},
+		{`{{prefixLen .Network}}`, "26"},
+		{`{{toJSON .List}}`, `["a","b"]`},
+		{`{{toYAML .Map}}`, "x: 1"},
+		{`{{"a\nb" | indent 2}}`, "  a\n  b"},
+		{`{{join ", " .List}}`, "a, b"},
+		{`{{join "," .Ints}}`, "1,2"},
+	}
+	for _, c := range cases {
+		tmpl := template.Must(template.New("").Funcs(FuncMap()).Parse(c.tmpl))
+		buf := new(bytes.Buffer)
+		err := tmpl.Execute(buf, args)
+		if err != nil {
+			t.Errorf("%s: %v", c.tmpl, err)
+			continue
+		}
+		if buf.String() != c.expected {
+			t.Errorf("%s: expected %q, actual %q", c.tmpl, c.expected, buf.String())
+		}
+	}
+
+	for _, tmpl := range []string{
+		`{{cidrHost .Network 64}}`,
+		`{{cidrHost .Network -65}}`,
+		`{{ipAdd "foo" 1}}`,
+		`{{prefixLen .IP}}`,
+		`{{join "," 1}}`,
+	} {
+		err := template.Must(template.New("").Funcs(FuncMap()).Parse(tmpl)).Execute(new(bytes.Buffer), args)
+		if err == nil {
+			t.Errorf("%s must be an error", tmpl)
+		}
+	}
+}
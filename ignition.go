@@ -0,0 +1,227 @@
+package menu
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ignitionVersion is the version of Ignition config generated by ConvertIgnition
+const ignitionVersion = "2.2.0"
+
+var (
+	systemdUnitSuffixes = []string{
+		".service", ".socket", ".target", ".timer", ".mount", ".automount",
+		".path", ".slice", ".scope", ".swap", ".device",
+	}
+	networkdUnitSuffixes = []string{".network", ".netdev", ".link"}
+)
+
+// ignitionSource is the YAML rendered from an Ignition template
+type ignitionSource struct {
+	Storage struct {
+		Files []struct {
+			Path     string `yaml:"path"`
+			Mode     *int   `yaml:"mode"`
+			Contents struct {
+				Inline string `yaml:"inline"`
+			} `yaml:"contents"`
+		} `yaml:"files"`
+	} `yaml:"storage"`
+	Systemd struct {
+		Units []struct {
+			Name     string `yaml:"name"`
+			Enabled  *bool  `yaml:"enabled"`
+			Mask     bool   `yaml:"mask"`
+			Contents string `yaml:"contents"`
+			Dropins  []struct {
+				Name     string `yaml:"name"`
+				Contents string `yaml:"contents"`
+			} `yaml:"dropins"`
+		} `yaml:"units"`
+	} `yaml:"systemd"`
+	Networkd struct {
+		Units []struct {
+			Name     string `yaml:"name"`
+			Contents string `yaml:"contents"`
+		} `yaml:"units"`
+	} `yaml:"networkd"`
+	Passwd struct {
+		Users []struct {
+			Name              string   `yaml:"name"`
+			PasswordHash      string   `yaml:"password_hash"`
+			SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys"`
+			Groups            []string `yaml:"groups"`
+		} `yaml:"users"`
+	} `yaml:"passwd"`
+}
+
+// IgnitionConfig is an Ignition config of spec version 2.2.0.
+// Only the fields generated by ConvertIgnition are defined.
+type IgnitionConfig struct {
+	Ignition struct {
+		Version string `json:"version"`
+	} `json:"ignition"`
+	Storage  IgnitionStorage  `json:"storage"`
+	Systemd  IgnitionSystemd  `json:"systemd"`
+	Networkd IgnitionNetworkd `json:"networkd"`
+	Passwd   IgnitionPasswd   `json:"passwd"`
+}
+
+// IgnitionStorage is the storage section of Ignition config
+type IgnitionStorage struct {
+	Files []IgnitionFile `json:"files,omitempty"`
+}
+
+// IgnitionFile is a file in Ignition config
+type IgnitionFile struct {
+	Filesystem string `json:"filesystem"`
+	Path       string `json:"path"`
+	Contents   struct {
+		Source string `json:"source"`
+	} `json:"contents"`
+	Mode int `json:"mode"`
+}
+
+// IgnitionSystemd is the systemd section of Ignition config
+type IgnitionSystemd struct {
+	Units []IgnitionUnit `json:"units,omitempty"`
+}
+
+// IgnitionUnit is a systemd unit in Ignition config
+type IgnitionUnit struct {
+	Name     string           `json:"name"`
+	Enabled  *bool            `json:"enabled,omitempty"`
+	Mask     bool             `json:"mask,omitempty"`
+	Contents string           `json:"contents,omitempty"`
+	Dropins  []IgnitionDropin `json:"dropins,omitempty"`
+}
+
+// IgnitionDropin is a drop-in of a systemd unit in Ignition config
+type IgnitionDropin struct {
+	Name     string `json:"name"`
+	Contents string `json:"contents"`
+}
+
+// IgnitionNetworkd is the networkd section of Ignition config
+type IgnitionNetworkd struct {
+	Units []IgnitionNetworkdUnit `json:"units,omitempty"`
+}
+
+// IgnitionNetworkdUnit is a networkd unit in Ignition config
+type IgnitionNetworkdUnit struct {
+	Name     string `json:"name"`
+	Contents string `json:"contents"`
+}
+
+// IgnitionPasswd is the passwd section of Ignition config
+type IgnitionPasswd struct {
+	Users []IgnitionUser `json:"users,omitempty"`
+}
+
+// IgnitionUser is a user in Ignition config
+type IgnitionUser struct {
+	Name              string   `json:"name"`
+	PasswordHash      string   `json:"passwordHash,omitempty"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+}
+
+func hasSuffix(name string, suffixes []string) bool {
+	for _, s := range suffixes {
+		if strings.HasSuffix(name, s) && len(name) > len(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConvertIgnition validates YAML rendered from an Ignition template, and
+// converts it into Ignition config JSON.
+func ConvertIgnition(data []byte) ([]byte, error) {
+	var src ignitionSource
+	err := yaml.UnmarshalStrict(data, &src)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg IgnitionConfig
+	cfg.Ignition.Version = ignitionVersion
+
+	paths := make(map[string]bool)
+	for _, f := range src.Storage.Files {
+		if !path.IsAbs(f.Path) || path.Clean(f.Path) != f.Path {
+			return nil, fmt.Errorf("path of file must be absolute and clean: %s", f.Path)
+		}
+		if paths[f.Path] {
+			return nil, errors.New("duplicate file: " + f.Path)
+		}
+		paths[f.Path] = true
+
+		file := IgnitionFile{Filesystem: "root", Path: f.Path, Mode: 0644}
+		if f.Mode != nil {
+			if *f.Mode < 0 || *f.Mode > 07777 {
+				return nil, fmt.Errorf("invalid mode of %s: %o", f.Path, *f.Mode)
+			}
+			file.Mode = *f.Mode
+		}
+		file.Contents.Source = "data:;base64," + base64.StdEncoding.EncodeToString([]byte(f.Contents.Inline))
+		cfg.Storage.Files = append(cfg.Storage.Files, file)
+	}
+
+	units := make(map[string]bool)
+	for _, u := range src.Systemd.Units {
+		if !hasSuffix(u.Name, systemdUnitSuffixes) || strings.Contains(u.Name, "/") {
+			return nil, errors.New("invalid name of systemd unit: " + u.Name)
+		}
+		if units[u.Name] {
+			return nil, errors.New("duplicate systemd unit: " + u.Name)
+		}
+		units[u.Name] = true
+
+		unit := IgnitionUnit{Name: u.Name, Enabled: u.Enabled, Mask: u.Mask, Contents: u.Contents}
+		for _, d := range u.Dropins {
+			if !strings.HasSuffix(d.Name, ".conf") || strings.Contains(d.Name, "/") {
+				return nil, fmt.Errorf("invalid name of drop-in of %s: %s", u.Name, d.Name)
+			}
+			unit.Dropins = append(unit.Dropins, IgnitionDropin{Name: d.Name, Contents: d.Contents})
+		}
+		cfg.Systemd.Units = append(cfg.Systemd.Units, unit)
+	}
+
+	units = make(map[string]bool)
+	for _, u := range src.Networkd.Units {
+		if !hasSuffix(u.Name, networkdUnitSuffixes) || strings.Contains(u.Name, "/") {
+			return nil, errors.New("invalid name of networkd unit: " + u.Name)
+		}
+		if units[u.Name] {
+			return nil, errors.New("duplicate networkd unit: " + u.Name)
+		}
+		units[u.Name] = true
+		cfg.Networkd.Units = append(cfg.Networkd.Units, IgnitionNetworkdUnit{Name: u.Name, Contents: u.Contents})
+	}
+
+	users := make(map[string]bool)
+	for _, u := range src.Passwd.Users {
+		if u.Name == "" {
+			return nil, errors.New("name of user is empty")
+		}
+		if users[u.Name] {
+			return nil, errors.New("duplicate user: " + u.Name)
+		}
+		users[u.Name] = true
+		cfg.Passwd.Users = append(cfg.Passwd.Users, IgnitionUser{
+			Name:              u.Name,
+			PasswordHash:      u.PasswordHash,
+			SSHAuthorizedKeys: u.SSHAuthorizedKeys,
+			Groups:            u.Groups,
+		})
+	}
+
+	return json.MarshalIndent(cfg, "", "  ")
+}
@@ -0,0 +1,127 @@
+package menu
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConvertIgnition(t *testing.T) {
+	t.Parallel()
+
+	source := `
+storage:
+  files:
+  - path: /etc/hostname
+    contents:
+      inline: rack0-cs1
+  - path: /opt/bin/setup
+    mode: 0755
+    contents:
+      inline: "#!/bin/sh\n"
+systemd:
+  units:
+  - name: bird.service
+    enabled: true
+    dropins:
+    - name: 10-restart.conf
+      contents: "[Service]\nRestart=always\n"
+  - name: locksmithd.service
+    mask: true
+networkd:
+  units:
+  - name: 10-node0.netdev
+    contents: "[NetDev]\nName=node0\nKind=dummy\n"
+passwd:
+  users:
+  - name: core
+    ssh_authorized_keys:
+    - ssh-ed25519 AAAA
+`
+	data, err := ConvertIgnition([]byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg IgnitionConfig
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Ignition.Version != "2.2.0" {
+		t.Errorf("unexpected version: %s", cfg.Ignition.Version)
+	}
+	files := cfg.Storage.Files
+	if len(files) != 2 || files[0].Mode != 0644 || files[1].Mode != 0755 {
+		t.Fatalf("unexpected files: %v", files)
+	}
+	if files[0].Filesystem != "root" || files[0].Contents.Source != "data:;base64,cmFjazAtY3Mx" {
+		t.Errorf("unexpected file: %v", files[0])
+	}
+	units := cfg.Systemd.Units
+	if len(units) != 2 || !*units[0].Enabled || len(units[0].Dropins) != 1 || !units[1].Mask || units[1].Enabled != nil {
+		t.Errorf("unexpected units: %v", units)
+	}
+	if len(cfg.Networkd.Units) != 1 || cfg.Networkd.Units[0].Name != "10-node0.netdev" {
+		t.Errorf("unexpected networkd units: %v", cfg.Networkd.Units)
+	}
+	if len(cfg.Passwd.Users) != 1 || cfg.Passwd.Users[0].SSHAuthorizedKeys[0] != "ssh-ed25519 AAAA" {
+		t.Errorf("unexpected users: %v", cfg.Passwd.Users)
+	}
+
+	errorSources := []string{
+		"storage: {files: [{path: etc/hostname}]}",
+		"storage: {files: [{path: /etc/../hostname}]}",
+		"storage: {files: [{path: /etc/hostname}, {path: /etc/hostname}]}",
+		"storage: {files: [{path: /etc/hostname, mode: 010000}]}",
+		"storage: {files: [{path: /etc/hostname, contents: {remote: http://example.com}}]}",
+		"systemd: {units: [{name: bird}]}",
+		"systemd: {units: [{name: bird.service}, {name: bird.service}]}",
+		"systemd: {units: [{name: bird.service, dropins: [{name: 10-restart}]}]}",
+		"networkd: {units: [{name: 10-node0.service}]}",
+		"passwd: {users: [{ssh_authorized_keys: [ssh-ed25519 AAAA]}]}",
+		"unknown: true",
+	}
+	for _, s := range errorSources {
+		_, err := ConvertIgnition([]byte(s))
+		if err == nil {
+			t.Error("err == nil", s)
+		}
+	}
+}
+
+func TestIgnitionVolumes(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, SS: 1, Boot: 1}})
+	m.Nodes[1].IgnitionTemplate = "ignition.yml.template"
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := generateCluster(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var folders []string
+	for _, f := range cluster.dataFolders {
+		if len(f.Files) == 1 && f.Files[0].Name == "config.ign" {
+			folders = append(folders, f.Name+":"+f.Files[0].File)
+		}
+	}
+	if len(folders) != 1 || folders[0] != "rack0-cs1-ignition:ignition_rack0-cs1.ign" {
+		t.Errorf("unexpected data folders: %v", folders)
+	}
+
+	for _, n := range cluster.nodes {
+		attached := false
+		for _, v := range n.Volumes {
+			if v.Name == "ignition" && v.Kind == "vvfat" && v.Folder == n.Name+"-ignition" {
+				attached = true
+			}
+		}
+		if attached != (n.Name == "rack0-cs1") {
+			t.Errorf("Ignition config must be attached only to rack0-cs1: %s", n.Name)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package menu
+
+import (
+	"io"
+	"net"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// nodeFallbackRouteMetric is the metric of the default routes via ToR switches
+// in the network-config.  They are used until BIRD installs the routes learned
+// by BGP, which have lower metrics.
+const nodeFallbackRouteMetric = 1024
+
+// NetworkConfig is a cloud-init network-config in netplan version 2 format
+type NetworkConfig struct {
+	Version      int                          `yaml:"version"`
+	Ethernets    map[string]*NetplanInterface `yaml:"ethernets"`
+	Bonds        map[string]*NetplanInterface `yaml:"bonds,omitempty"`
+	DummyDevices map[string]*NetplanInterface `yaml:"dummy-devices,omitempty"`
+}
+
+// NetplanInterface is an interface definition of netplan
+type NetplanInterface struct {
+	Interfaces []string       `yaml:"interfaces,omitempty"`
+	Parameters *NetplanBond   `yaml:"parameters,omitempty"`
+	Addresses  []string       `yaml:"addresses,omitempty"`
+	Routes     []NetplanRoute `yaml:"routes,omitempty"`
+	LinkLocal  []string       `yaml:"link-local"`
+	AcceptRA   bool           `yaml:"accept-ra"`
+}
+
+// NetplanBond is the parameters of a bond interface of netplan
+type NetplanBond struct {
+	Mode               string `yaml:"mode"`
+	MIIMonitorInterval int    `yaml:"mii-monitor-interval"`
+}
+
+// NetplanRoute is a static route of netplan
+type NetplanRoute struct {
+	To     string `yaml:"to"`
+	Via    string `yaml:"via"`
+	Metric int    `yaml:"metric,omitempty"`
+}
+
+// netplanInterface returns an interface with addresses.  The default routes
+// via gateways are added if any.
+func netplanInterface(addresses []*net.IPNet, gateways ...*net.IPNet) *NetplanInterface {
+	iface := &NetplanInterface{}
+	for _, a := range addresses {
+		iface.Addresses = append(iface.Addresses, a.String())
+	}
+	for _, gw := range gateways {
+		if gw == nil {
+			continue
+		}
+		iface.Routes = append(iface.Routes, NetplanRoute{
+			To:     "0.0.0.0/0",
+			Via:    gw.IP.String(),
+			Metric: nodeFallbackRouteMetric,
+		})
+	}
+	return iface
+}
+
+// NodeNetworkConfig returns the network-config of the node.  It is the
+// netplan equivalent of NodeNetworkdFiles, plus the fallback default routes
+// via ToR switches.
+func NodeNetworkConfig(node Node) *NetworkConfig {
+	nc := &NetworkConfig{
+		Version:   2,
+		Ethernets: make(map[string]*NetplanInterface),
+		DummyDevices: map[string]*NetplanInterface{
+			nodeDummyInterface: netplanInterface([]*net.IPNet{node.Node0Address}),
+		},
+	}
+
+	if node.Bond {
+		bond := netplanInterface([]*net.IPNet{node.Node1Address}, node.ToR1Address, node.ToR2Address)
+		bond.Parameters = &NetplanBond{
+			Mode:               nodeBondMode,
+			MIIMonitorInterval: 100,
+		}
+		for i := 0; i < node.NICs; i++ {
+			bond.Interfaces = append(bond.Interfaces, nodeNICName(i))
+			nc.Ethernets[nodeNICName(i)] = netplanInterface(nil)
+		}
+		nc.Bonds = map[string]*NetplanInterface{nodeBondInterface: bond}
+	} else {
+		links := []struct{ address, gateway *net.IPNet }{
+			{node.Node1Address, node.ToR1Address},
+			{node.Node2Address, node.ToR2Address},
+		}
+		for i, l := range links {
+			if i >= node.NICs || l.address == nil {
+				break
+			}
+			nc.Ethernets[nodeNICName(i)] = netplanInterface([]*net.IPNet{l.address}, l.gateway)
+		}
+	}
+
+	if node.StorageAddress != nil {
+		stor := netplanInterface([]*net.IPNet{node.StorageAddress})
+		stor.Routes = append(stor.Routes, NetplanRoute{
+			To:  node.StorageNetwork.String(),
+			Via: node.StorageToRAddress.IP.String(),
+		})
+		nc.Ethernets[nodeNICName(node.NICs)] = stor
+	}
+	return nc
+}
+
+// BootNodeNetworkConfig returns the network-config of the boot server.
+// The bastion address is assigned to node0 interface in addition to node0 address.
+func BootNodeNetworkConfig(boot BootNodeEntity) *NetworkConfig {
+	nc := NodeNetworkConfig(boot.Node)
+	if boot.BastionAddress != nil {
+		node0 := nc.DummyDevices[nodeDummyInterface]
+		node0.Addresses = append(node0.Addresses, boot.BastionAddress.String())
+	}
+	return nc
+}
+
+// ExportNetworkConfig exports a network-config file used in cloud-init
+func ExportNetworkConfig(w io.Writer, nc *NetworkConfig) error {
+	return yaml.NewEncoder(w).Encode(nc)
+}
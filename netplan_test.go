@@ -0,0 +1,101 @@
+package menu
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNodeNetworkConfig(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, SS: 1, Boot: 1}})
+	m.Network.Bastion = mustParseCIDR("10.72.48.0/26")
+	m.Nodes[1].NICs = 2
+	m.Nodes[1].Bond = true
+	m.Nodes[2].NICs = 1
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rack := ta.Racks[0]
+
+	boot := BootNodeNetworkConfig(rack.BootNodes[0])
+	node0 := boot.DummyDevices["node0"]
+	if node0 == nil || len(node0.Addresses) != 2 || node0.Addresses[1] != "10.72.48.0/32" {
+		t.Errorf("node0 of boot server must have the bastion address: %v", node0)
+	}
+	if len(boot.Ethernets) != 2 || boot.Bonds != nil {
+		t.Fatalf("boot server must have routed eth0 and eth1: %v", boot.Ethernets)
+	}
+	for i, tor := range rack.ToRs() {
+		eth := boot.Ethernets[nodeNICName(i)]
+		if len(eth.Routes) != 1 || eth.Routes[0].Via != tor.NodeAddress.IP.String() {
+			t.Errorf("unexpected routes of %s: %v", nodeNICName(i), eth.Routes)
+		}
+	}
+
+	cs := NodeNetworkConfig(rack.CSList[0])
+	bond := cs.Bonds["bond0"]
+	if bond == nil || len(bond.Interfaces) != 2 || len(bond.Routes) != 2 {
+		t.Fatalf("cs must have bond0 over eth0 and eth1 with routes via both ToR switches: %v", bond)
+	}
+	if bond.Addresses[0] != rack.CSList[0].Node1Address.String() {
+		t.Errorf("unexpected address of bond0: %v", bond.Addresses)
+	}
+	if len(cs.Ethernets["eth0"].Addresses) != 0 {
+		t.Error("bonded NICs must not have addresses")
+	}
+
+	ss := NodeNetworkConfig(rack.SSList[0])
+	if len(ss.Ethernets) != 1 || ss.Ethernets["eth0"] == nil {
+		t.Errorf("ss with one NIC must have only eth0: %v", ss.Ethernets)
+	}
+}
+
+func TestNodeStorageRoutes(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, SS: 1, Boot: 1}, {SS: 1, Boot: 1}})
+	m.Network.Storage = mustParseCIDR("10.70.0.0/20")
+	m.Network.StorageRangeSize = 6
+	m.Network.StorageBackbone = mustParseCIDR("10.70.255.0/24")
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := ta.Racks[0].SSList[0]
+	gateway := ta.Racks[0].StorageToR.NodeAddress.IP.String()
+	remote := ta.Racks[1].StorageToR.NodeNetwork
+
+	stor := NodeNetworkConfig(ss).Ethernets[nodeNICName(ss.NICs)]
+	if stor == nil {
+		t.Fatal("storage NIC is not configured")
+	}
+	found := false
+	for _, r := range stor.Routes {
+		_, to, err := net.ParseCIDR(r.To)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := to.Mask.Size()
+		remoteOnes, _ := remote.Mask.Size()
+		if r.Via == gateway && to.Contains(remote.IP) && ones <= remoteOnes {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("storage network of rack1 must be routed via %s: %v", gateway, stor.Routes)
+	}
+
+	expected := "[Route]\nDestination=10.70.0.0/20\nGateway=" + gateway + "\n"
+	found = false
+	for _, f := range NodeNetworkdFiles(ss) {
+		if f.Name == "30-"+nodeNICName(ss.NICs)+".network" && strings.Contains(f.Content, expected) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("storage network must be routed via %s in networkd files", gateway)
+	}
+}
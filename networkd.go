@@ -0,0 +1,89 @@
+package menu
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+)
+
+const (
+	// nodeDummyInterface is the dummy interface holding node0 address of a node
+	nodeDummyInterface = "node0"
+
+	// nodeBondInterface is the bond interface of a node with bonded NICs
+	nodeBondInterface = "bond0"
+
+	// nodeBondMode is the mode of the bond interface.  All the bonded NICs are
+	// connected to one placemat bridge which does not speak LACP, so 802.3ad
+	// cannot negotiate.
+	nodeBondMode = "active-backup"
+)
+
+// NetworkdFile is a configuration file of systemd-networkd
+type NetworkdFile struct {
+	Name    string
+	Content string
+}
+
+// nodeNICName returns the name of the NIC of a node in the order of the
+// interfaces in cluster.yml.  Nodes must be booted with net.ifnames=0.
+func nodeNICName(idx int) string {
+	return fmt.Sprintf("eth%d", idx)
+}
+
+// networkdNetDev returns a .netdev file.  section is appended after [NetDev] section if not empty.
+func networkdNetDev(prefix, name, kind, section string) NetworkdFile {
+	content := fmt.Sprintf("[NetDev]\nName=%s\nKind=%s\n", name, kind)
+	if section != "" {
+		content += "\n" + section
+	}
+	return NetworkdFile{Name: prefix + name + ".netdev", Content: content}
+}
+
+// networkdNetwork returns a .network file.  If bond is not empty, the interface is enslaved to it.
+func networkdNetwork(prefix, name string, addresses []*net.IPNet, bond string) NetworkdFile {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "[Match]\nName=%s\n\n[Network]\n", name)
+	if bond != "" {
+		fmt.Fprintf(buf, "Bond=%s\n", bond)
+	}
+	for _, a := range addresses {
+		fmt.Fprintf(buf, "Address=%s\n", a)
+	}
+	buf.WriteString("LinkLocalAddressing=no\nIPv6AcceptRA=no\n")
+	return NetworkdFile{Name: prefix + name + ".network", Content: buf.String()}
+}
+
+// NodeNetworkdFiles returns systemd-networkd configuration files of the node.
+// They create node0 dummy interface with node0 address, and configure the
+// NICs connected to node1, node2 and storage networks.  The storage networks
+// of the other racks are routed via the storage ToR switch.
+func NodeNetworkdFiles(node Node) []NetworkdFile {
+	files := []NetworkdFile{
+		networkdNetDev("10-", nodeDummyInterface, "dummy", ""),
+		networkdNetwork("10-", nodeDummyInterface, []*net.IPNet{node.Node0Address}, ""),
+	}
+
+	if node.Bond {
+		files = append(files, networkdNetDev("20-", nodeBondInterface, "bond",
+			fmt.Sprintf("[Bond]\nMode=%s\nMIIMonitorSec=100ms\n", nodeBondMode)))
+		for i := 0; i < node.NICs; i++ {
+			files = append(files, networkdNetwork("30-", nodeNICName(i), nil, nodeBondInterface))
+		}
+		files = append(files, networkdNetwork("40-", nodeBondInterface, []*net.IPNet{node.Node1Address}, ""))
+	} else {
+		for i, address := range []*net.IPNet{node.Node1Address, node.Node2Address} {
+			if i >= node.NICs || address == nil {
+				break
+			}
+			files = append(files, networkdNetwork("30-", nodeNICName(i), []*net.IPNet{address}, ""))
+		}
+	}
+
+	if node.StorageAddress != nil {
+		stor := networkdNetwork("30-", nodeNICName(node.NICs), []*net.IPNet{node.StorageAddress}, "")
+		stor.Content += fmt.Sprintf("\n[Route]\nDestination=%s\nGateway=%s\n", node.StorageNetwork, node.StorageToRAddress.IP)
+		files = append(files, stor)
+	}
+	return files
+}
@@ -0,0 +1,66 @@
+package menu
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNodeNetworkdFiles(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, SS: 1, Boot: 1}})
+	m.Nodes[1].NICs = 2
+	m.Nodes[1].Bond = true
+	m.Nodes[2].NICs = 1
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rack := ta.Racks[0]
+
+	cases := []struct {
+		node     Node
+		expected map[string]string
+	}{
+		{
+			rack.BootNodes[0].Node,
+			map[string]string{
+				"10-node0.netdev":  "Kind=dummy",
+				"10-node0.network": "Address=10.69.0.3/32",
+				"30-eth0.network":  "Address=10.69.0.67/26",
+				"30-eth1.network":  "Address=10.69.0.131/26",
+			},
+		},
+		{
+			rack.CSList[0],
+			map[string]string{
+				"10-node0.netdev":  "Kind=dummy",
+				"10-node0.network": "Address=10.69.0.4/32",
+				"20-bond0.netdev":  "Mode=active-backup",
+				"30-eth0.network":  "Bond=bond0",
+				"30-eth1.network":  "Bond=bond0",
+				"40-bond0.network": "Address=10.69.0.68/26",
+			},
+		},
+		{
+			rack.SSList[0],
+			map[string]string{
+				"10-node0.netdev":  "Kind=dummy",
+				"10-node0.network": "Address=10.69.0.5/32",
+				"30-eth0.network":  "Address=10.69.0.69/26",
+			},
+		},
+	}
+	for _, c := range cases {
+		files := NodeNetworkdFiles(c.node)
+		if len(files) != len(c.expected) {
+			t.Errorf("unexpected files for %s: %v", c.node.Fullname, files)
+			continue
+		}
+		for _, f := range files {
+			if !strings.Contains(f.Content, c.expected[f.Name]) {
+				t.Errorf("%s of %s must contain %s: %s", f.Name, c.node.Fullname, c.expected[f.Name], f.Content)
+			}
+		}
+	}
+}
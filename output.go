@@ -0,0 +1,215 @@
+package menu
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/cybozu-go/placemat"
+)
+
+const (
+	scopeCluster = "cluster"
+	scopeRack    = "rack"
+	scopeNode    = "node"
+	scopeSpine   = "spine"
+	scopeToR     = "tor"
+)
+
+// OutputContext is the context of OutputFile templates.
+// Fields other than Name and Cluster are nil unless they are in the scope.
+type OutputContext struct {
+	// Name is the name of the element, e.g. "rack0", "rack0-cs1" or "spine1".
+	// It is "cluster" for the cluster scope.
+	Name string
+	// Cluster is the whole template args of the cluster
+	Cluster *TemplateArgs
+	// Rack is the rack for rack, node and tor scopes
+	Rack *Rack
+	// Node is the node for node scope
+	Node *CloudInitContext
+	// Spine is the spine switch for spine scope
+	Spine *Spine
+	// ToR is the ToR switch for tor scope
+	ToR *ToR
+}
+
+// OutputTarget is an output file rendered for an element of the scope
+type OutputTarget struct {
+	// Path is the path of the file relative to the output directory
+	Path    string
+	Context *OutputContext
+}
+
+// cleanOutputPath returns the cleaned path if p is a path in the output directory
+func cleanOutputPath(p string) (string, error) {
+	p = filepath.Clean(p)
+	if filepath.IsAbs(p) || p == "." || p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not in the output directory", p)
+	}
+	return p, nil
+}
+
+// OutputContexts returns the contexts for each element in the scope.
+// nodes are the contexts of nodes built by the caller, e.g. with BIRD set.
+// Contexts of nodes not in nodes are built by NewCloudInitContext.
+func OutputContexts(ta *TemplateArgs, scope string, nodes map[string]*CloudInitContext) ([]*OutputContext, error) {
+	var ctxs []*OutputContext
+	switch scope {
+	case scopeCluster:
+		ctxs = append(ctxs, &OutputContext{Name: scopeCluster, Cluster: ta})
+	case scopeRack:
+		for i := range ta.Racks {
+			rack := &ta.Racks[i]
+			ctxs = append(ctxs, &OutputContext{Name: rack.Name, Cluster: ta, Rack: rack})
+		}
+	case scopeNode:
+		for i := range ta.Racks {
+			rack := &ta.Racks[i]
+			for _, node := range rack.Nodes() {
+				nc := nodes[node.Fullname]
+				if nc == nil {
+					var err error
+					nc, err = NewCloudInitContext(ta, i, node.Fullname)
+					if err != nil {
+						return nil, err
+					}
+				}
+				ctxs = append(ctxs, &OutputContext{Name: node.Fullname, Cluster: ta, Rack: rack, Node: nc})
+			}
+		}
+	case scopeSpine:
+		for i := range ta.Spines {
+			spine := &ta.Spines[i]
+			ctxs = append(ctxs, &OutputContext{Name: spine.Name, Cluster: ta, Spine: spine})
+		}
+	case scopeToR:
+		for i := range ta.Racks {
+			rack := &ta.Racks[i]
+			for _, tor := range []*ToR{&rack.ToR1, &rack.ToR2} {
+				ctxs = append(ctxs, &OutputContext{Name: tor.Name, Cluster: ta, Rack: rack, ToR: tor})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown scope: %s", scope)
+	}
+	return ctxs, nil
+}
+
+// OutputTargets returns the output files of o for each element in its scope.
+// nodes is passed to OutputContexts.
+func (ta *TemplateArgs) OutputTargets(o *OutputFileMenu, nodes map[string]*CloudInitContext) ([]OutputTarget, error) {
+	tmpl, err := template.New(o.Name).Funcs(FuncMap()).Parse(o.Output)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output of OutputFile %s: %v", o.Name, err)
+	}
+	ctxs, err := OutputContexts(ta, o.Scope, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []OutputTarget
+	paths := make(map[string]bool)
+	for _, ctx := range ctxs {
+		buf := new(bytes.Buffer)
+		err := tmpl.Execute(buf, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output of OutputFile %s: %v", o.Name, err)
+		}
+		p, err := cleanOutputPath(buf.String())
+		if err != nil {
+			return nil, fmt.Errorf("invalid output of OutputFile %s: %v", o.Name, err)
+		}
+		if paths[p] {
+			return nil, fmt.Errorf("output of OutputFile %s is not unique in the scope: %s", o.Name, p)
+		}
+		paths[p] = true
+		targets = append(targets, OutputTarget{Path: p, Context: ctx})
+	}
+	return targets, nil
+}
+
+// attachOutputFiles attaches OutputFiles to the nodes and the pods of their elements
+func (c *cluster) attachOutputFiles(ta *TemplateArgs) error {
+	for _, o := range ta.OutputFiles {
+		if !o.Attach {
+			continue
+		}
+		targets, err := ta.OutputTargets(o, nil)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range targets {
+			folder := fmt.Sprintf("%s-%s", o.Name, t.Context.Name)
+			c.dataFolders = append(c.dataFolders, &placemat.DataFolderSpec{
+				Kind: "DataFolder",
+				Name: folder,
+				Files: []placemat.DataFolderFileSpec{
+					{
+						Name: filepath.Base(t.Path),
+						File: t.Path,
+					},
+				},
+			})
+
+			if o.Scope == scopeNode {
+				err = c.attachNodeFolder(t.Context.Name, o.Name, folder)
+			} else {
+				err = c.attachPodFolder(t.Context.Name, o.Name, folder)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// attachNodeFolder adds a vvfat volume of the data folder to the node
+func (c *cluster) attachNodeFolder(nodeName, volume, folder string) error {
+	for _, n := range c.nodes {
+		if n.Name != nodeName {
+			continue
+		}
+		n.Volumes = append(n.Volumes, placemat.NodeVolumeSpec{
+			Kind:   "vvfat",
+			Name:   volume,
+			Folder: folder,
+		})
+		return nil
+	}
+	return fmt.Errorf("node %s is not found", nodeName)
+}
+
+// attachPodFolder adds a volume of the data folder to the pod, and mounts it
+// on /mnt/<volume> of the debug container
+func (c *cluster) attachPodFolder(podName, volume, folder string) error {
+	for _, p := range c.pods {
+		if p.Name != podName {
+			continue
+		}
+		p.Volumes = append(p.Volumes, &placemat.PodVolumeSpec{
+			Name:     volume,
+			Kind:     "host",
+			Folder:   folder,
+			ReadOnly: true,
+		})
+		for i, app := range p.Apps {
+			if app.Name != debugContainer.Name {
+				continue
+			}
+			// apps may be shared among pods
+			debug := *app
+			debug.Mount = append(append([]placemat.PodAppMountSpec(nil), app.Mount...), placemat.PodAppMountSpec{
+				Volume: volume,
+				Target: "/mnt/" + volume,
+			})
+			p.Apps[i] = &debug
+		}
+		return nil
+	}
+	return fmt.Errorf("pod %s is not found", podName)
+}
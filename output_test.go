@@ -0,0 +1,116 @@
+package menu
+
+import (
+	"testing"
+)
+
+func TestOutputTargets(t *testing.T) {
+	t.Parallel()
+
+	ta, err := ToTemplateArgs(testMenu(2, []RackMenu{{CS: 1, Boot: 1}, {SS: 1, Boot: 1}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		scope    string
+		output   string
+		expected []string
+	}{
+		{scopeCluster, "hosts", []string{"hosts"}},
+		{scopeRack, "{{.Rack.Name}}/notes", []string{"rack0/notes", "rack1/notes"}},
+		{scopeNode, "{{.Node.Type}}-{{.Name}}.txt", []string{"boot-boot-0.txt", "cs-rack0-cs1.txt", "boot-boot-1.txt", "ss-rack1-ss1.txt"}},
+		{scopeSpine, "{{.Spine.Name}}", []string{"spine1", "spine2"}},
+		{scopeToR, "{{.Rack.Name}}/{{.ToR.Name}}", []string{"rack0/rack0-tor1", "rack0/rack0-tor2", "rack1/rack1-tor1", "rack1/rack1-tor2"}},
+	}
+	for _, c := range cases {
+		targets, err := ta.OutputTargets(&OutputFileMenu{Name: "test", Output: c.output, Scope: c.scope}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", c.scope, err)
+			continue
+		}
+		if len(targets) != len(c.expected) {
+			t.Errorf("%s: unexpected targets: %v", c.scope, targets)
+			continue
+		}
+		for i, target := range targets {
+			if target.Path != c.expected[i] {
+				t.Errorf("%s: expected %s, actual %s", c.scope, c.expected[i], target.Path)
+			}
+		}
+	}
+
+	nodes := map[string]*CloudInitContext{"boot-0": {Name: "boot-0", BIRD: "bird"}}
+	targets, err := ta.OutputTargets(&OutputFileMenu{Name: "test", Output: "{{.Name}}", Scope: scopeNode}, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if targets[0].Context.Node.BIRD != "bird" || targets[1].Context.Node.Type != "cs" {
+		t.Error("contexts of nodes must be taken from nodes if exist")
+	}
+
+	for _, o := range []*OutputFileMenu{
+		{Name: "dup", Output: "same", Scope: scopeRack},
+		{Name: "abs", Output: "/etc/hosts"},
+		{Name: "parent", Output: "../hosts"},
+		{Name: "invalid", Output: "{{.Foo}}"},
+	} {
+		_, err := ta.OutputTargets(o, nil)
+		if err == nil {
+			t.Errorf("%s must be an error", o.Name)
+		}
+	}
+}
+
+func TestAttachOutputFiles(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, Boot: 1}})
+	m.OutputFiles = []*OutputFileMenu{
+		{Name: "info", Output: "info/{{.Name}}", Scope: scopeNode, Attach: true},
+		{Name: "notes", Output: "notes/{{.Name}}", Scope: scopeToR, Attach: true},
+		{Name: "hosts", Output: "hosts", Scope: scopeCluster},
+	}
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := generateCluster(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folders := make(map[string]string)
+	for _, f := range cluster.dataFolders {
+		if len(f.Files) == 1 {
+			folders[f.Name] = f.Files[0].File
+		}
+	}
+	if folders["info-rack0-cs1"] != "info/rack0-cs1" || folders["notes-rack0-tor2"] != "notes/rack0-tor2" {
+		t.Errorf("unexpected data folders: %v", folders)
+	}
+	if _, ok := folders["hosts-cluster"]; ok {
+		t.Error("hosts must not be attached")
+	}
+
+	for _, n := range cluster.nodes {
+		v := n.Volumes[len(n.Volumes)-1]
+		if v.Name != "info" || v.Kind != "vvfat" || v.Folder != "info-"+n.Name {
+			t.Errorf("unexpected volume of %s: %v", n.Name, v)
+		}
+	}
+
+	mounted := 0
+	for _, p := range cluster.pods {
+		for _, app := range p.Apps {
+			for _, mount := range app.Mount {
+				if mount.Volume == "notes" {
+					mounted++
+				}
+			}
+		}
+	}
+	if mounted != 2 || len(debugContainer.Mount) != 0 {
+		t.Errorf("notes must be mounted only on the debug containers of ToR pods: %d", mounted)
+	}
+}
@@ -0,0 +1,169 @@
+package menu
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cybozu-go/placemat"
+)
+
+const (
+	// pluginPrefix is the prefix of plugin executables looked up in $PATH
+	pluginPrefix = "placemat-menu-gen-"
+
+	// pluginProtocolVersion is the version of PluginInput
+	pluginProtocolVersion = 1
+
+	defaultPluginFileMode = 0644
+)
+
+// PluginInput is given to plugins as JSON on stdin.
+// Menu does not have the seed and secrets of BGP authentication, and Args
+// does not have the passwords of BGP sessions.
+type PluginInput struct {
+	Version int           `json:"version"`
+	Args    *TemplateArgs `json:"args"`
+	Menu    *Menu         `json:"menu"`
+}
+
+// PluginOutput is returned by plugins as JSON on stdout
+type PluginOutput struct {
+	Files []PluginFile `json:"files"`
+}
+
+// PluginFile is a file generated by a plugin
+type PluginFile struct {
+	// Path is the path of the file relative to the output directory
+	Path string `json:"path"`
+	// Mode is the permission bits in octal, e.g. "0755" (default: "0644")
+	Mode string `json:"mode,omitempty"`
+	// Content is the content of the file
+	Content string `json:"content"`
+	// DataFolder is the name of DataFolder in cluster.yml to register the file (optional)
+	DataFolder string `json:"data-folder,omitempty"`
+}
+
+// FileMode returns the mode of the file
+func (f PluginFile) FileMode() (os.FileMode, error) {
+	if f.Mode == "" {
+		return defaultPluginFileMode, nil
+	}
+	mode, err := strconv.ParseUint(f.Mode, 8, 32)
+	if err != nil || mode&^uint64(os.ModePerm) != 0 {
+		return 0, fmt.Errorf("invalid mode of %s: %s", f.Path, f.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// LookPlugin returns the path of the plugin.  A name containing a path
+// separator is the path of the plugin.  Otherwise, placemat-menu-gen-<name>
+// is looked up in $PATH.
+func LookPlugin(name string) (string, error) {
+	if strings.ContainsRune(name, filepath.Separator) {
+		return name, nil
+	}
+	return exec.LookPath(pluginPrefix + name)
+}
+
+// redactMenu returns a copy of m without the secrets of BGP authentication
+func redactMenu(m *Menu) *Menu {
+	if m.Network == nil || m.Network.BGPAuth == nil {
+		return m
+	}
+	redacted := *m
+	network := *m.Network
+	network.BGPAuth = &BGPAuthMenu{Nodes: m.Network.BGPAuth.Nodes}
+	redacted.Network = &network
+	return &redacted
+}
+
+// RunPlugin runs the plugin and returns the files generated by it
+func RunPlugin(name string, m *Menu, ta *TemplateArgs) ([]PluginFile, error) {
+	path, err := LookPlugin(name)
+	if err != nil {
+		return nil, err
+	}
+	input, err := json.Marshal(PluginInput{Version: pluginProtocolVersion, Args: ta, Menu: redactMenu(m)})
+	if err != nil {
+		return nil, err
+	}
+
+	stdout := new(bytes.Buffer)
+	cmd := exec.Command(path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s failed: %v", name, err)
+	}
+
+	var output PluginOutput
+	err = json.Unmarshal(stdout.Bytes(), &output)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output of plugin %s: %v", name, err)
+	}
+	for i := range output.Files {
+		f := &output.Files[i]
+		f.Path, err = cleanOutputPath(f.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output of plugin %s: %v", name, err)
+		}
+		_, err = f.FileMode()
+		if err != nil {
+			return nil, fmt.Errorf("invalid output of plugin %s: %v", name, err)
+		}
+	}
+	return output.Files, nil
+}
+
+// RunPlugins runs all the plugins in the menu, and registers the files to
+// ta.DataFolders if requested.  Paths of the files must be unique.
+func RunPlugins(m *Menu, ta *TemplateArgs) ([]PluginFile, error) {
+	if m.Generator == nil {
+		return nil, nil
+	}
+
+	var files []PluginFile
+	paths := make(map[string]string)
+	folders := make(map[string]*placemat.DataFolderSpec)
+	for _, name := range m.Generator.Plugins {
+		pluginFiles, err := RunPlugin(name, m, ta)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range pluginFiles {
+			if p, ok := paths[f.Path]; ok {
+				return nil, fmt.Errorf("%s is generated by both plugin %s and %s", f.Path, p, name)
+			}
+			paths[f.Path] = name
+			files = append(files, f)
+
+			if f.DataFolder == "" {
+				continue
+			}
+			folder := folders[f.DataFolder]
+			if folder == nil {
+				folder = &placemat.DataFolderSpec{Kind: "DataFolder", Name: f.DataFolder}
+				folders[f.DataFolder] = folder
+				ta.DataFolders = append(ta.DataFolders, folder)
+			}
+			for _, ff := range folder.Files {
+				if ff.Name == filepath.Base(f.Path) {
+					return nil, fmt.Errorf("DataFolder %s has two files named %s", folder.Name, ff.Name)
+				}
+			}
+			folder.Files = append(folder.Files, placemat.DataFolderFileSpec{
+				Name: filepath.Base(f.Path),
+				File: f.Path,
+			})
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,120 @@
+package menu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePlugin(t *testing.T, dir, name, output string) string {
+	p := filepath.Join(dir, name)
+	script := "#!/bin/sh\ncat > " + filepath.Join(dir, name+".input") + "\ncat <<'EOF'\n" + output + "\nEOF\n"
+	err := ioutil.WriteFile(p, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRunPlugins(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "placemat-menu-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := testMenu(2, []RackMenu{{CS: 1, Boot: 1}})
+	m.Generator = &GeneratorMenu{
+		Plugins: []string{
+			writePlugin(t, dir, "hosts", `{"files": [
+{"path": "hosts/./hosts", "content": "hosts", "data-folder": "extra"},
+{"path": "run.sh", "mode": "0755", "content": "#!/bin/sh"}
+]}`),
+			writePlugin(t, dir, "notes", `{"files": [{"path": "notes", "content": "notes", "data-folder": "extra"}]}`),
+		},
+	}
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := RunPlugins(m, ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[0].Path != "hosts/hosts" || files[2].Content != "notes" {
+		t.Errorf("unexpected files: %v", files)
+	}
+	if mode, _ := files[0].FileMode(); mode != 0644 {
+		t.Errorf("unexpected default mode: %v", mode)
+	}
+	if mode, _ := files[1].FileMode(); mode != 0755 {
+		t.Errorf("unexpected mode: %v", mode)
+	}
+	if len(ta.DataFolders) != 1 || len(ta.DataFolders[0].Files) != 2 || ta.DataFolders[0].Files[0].File != "hosts/hosts" {
+		t.Errorf("unexpected data folders: %v", ta.DataFolders)
+	}
+
+	input, err := ioutil.ReadFile(filepath.Join(dir, "hosts.input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(input), `"version":1`) || !strings.Contains(string(input), `"ClusterID":"dev0"`) {
+		t.Errorf("unexpected input: %s", input)
+	}
+
+	m.Network.BGPAuth = &BGPAuthMenu{
+		Seed:    "plugin-seed",
+		Secrets: map[string]string{bgpSessionKey("core", "spine1"): "plugin-secret"},
+	}
+	authTA, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = RunPlugin(m.Generator.Plugins[0], m, authTA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, err = ioutil.ReadFile(filepath.Join(dir, "hosts.input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"plugin-seed", "plugin-secret", authTA.BGPPassword("spine1", "rack0-tor1")} {
+		if strings.Contains(string(input), secret) {
+			t.Errorf("input must not contain BGP secrets: %s", secret)
+		}
+	}
+	if m.Network.BGPAuth.Seed != "plugin-seed" {
+		t.Error("menu must not be modified")
+	}
+	m.Network.BGPAuth = nil
+
+	_, err = generateCluster(ta)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, output := range []string{
+		`{"files": [{"path": "../hosts", "content": ""}]}`,
+		`{"files": [{"path": "hosts", "mode": "rw", "content": ""}]}`,
+		`{"files": [{"path": "hosts", "content": ""}, {"path": "hosts", "content": ""}]}`,
+		`{"files": [{"path": "a/hosts", "content": "", "data-folder": "x"}, {"path": "b/hosts", "content": "", "data-folder": "x"}]}`,
+		`not json`,
+	} {
+		m.Generator.Plugins = []string{writePlugin(t, dir, "invalid", output)}
+		_, err := RunPlugins(m, ta)
+		if err == nil {
+			t.Errorf("%s must be an error", output)
+		}
+	}
+
+	m.Generator.Plugins = []string{"no-such-plugin"}
+	_, err = RunPlugins(m, ta)
+	if err == nil {
+		t.Error("plugin not in $PATH must be an error")
+	}
+}
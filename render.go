@@ -0,0 +1,100 @@
+package menu
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var (
+	// errors of text/template are formatted as "template: NAME:LINE[:COL]: MESSAGE"
+	templateErrorPattern = regexp.MustCompile(`(?s)^template: (.*?):(\d+):(?:(\d+):)? (.*)$`)
+	quotedPattern        = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+)
+
+// TemplateError is an error in parsing or executing a template file
+type TemplateError struct {
+	File string
+	// Line is 1-based, or 0 if unknown
+	Line int
+	// Column is the 1-based byte offset in the line, or 0 if unknown
+	Column int
+	Msg    string
+}
+
+func (e *TemplateError) Error() string {
+	switch {
+	case e.Line == 0:
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	case e.Column == 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+// newTemplateError converts an error of text/template into *TemplateError.
+// text is the content of the template, used to locate the column of parse errors.
+func newTemplateError(file, text string, err error) *TemplateError {
+	m := templateErrorPattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return &TemplateError{File: file, Msg: strings.TrimPrefix(err.Error(), "template: ")}
+	}
+	e := &TemplateError{File: file, Msg: m[4]}
+	e.Line, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		// columns of execution errors are 0-based
+		col, _ := strconv.Atoi(m[3])
+		e.Column = col + 1
+		e.Msg = strings.TrimPrefix(e.Msg, fmt.Sprintf("executing %q ", file))
+	} else {
+		e.Column = findColumn(text, e.Line, e.Msg)
+	}
+	return e
+}
+
+// findColumn returns the column of the first quoted token of msg in the line
+// of text, or 0 if not found.  Parse errors of text/template only have lines,
+// but most of them quote the offending token, e.g. `function "foo" not defined`.
+func findColumn(text string, line int, msg string) int {
+	lines := strings.Split(text, "\n")
+	if line < 1 || line > len(lines) {
+		return 0
+	}
+	q := quotedPattern.FindString(msg)
+	if q == "" {
+		return 0
+	}
+	token, err := strconv.Unquote(q)
+	if err != nil || token == "" {
+		return 0
+	}
+	idx := strings.Index(lines[line-1], token)
+	if idx < 0 {
+		return 0
+	}
+	return idx + 1
+}
+
+// ParseTemplate parses text of the template file with FuncMap.
+// Errors are returned as *TemplateError.
+func ParseTemplate(file, text string) (*template.Template, error) {
+	tmpl, err := template.New(file).Funcs(FuncMap()).Parse(text)
+	if err != nil {
+		return nil, newTemplateError(file, text, err)
+	}
+	return tmpl, nil
+}
+
+// ExecuteTemplate executes the template parsed by ParseTemplate, and returns
+// the result.  Errors are returned as *TemplateError.
+func ExecuteTemplate(tmpl *template.Template, args interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := tmpl.Execute(buf, args)
+	if err != nil {
+		return nil, newTemplateError(tmpl.Name(), "", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,65 @@
+package menu
+
+import (
+	"testing"
+)
+
+func TestParseTemplate(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		text     string
+		expected string
+	}{
+		{"a\n  {{nofunc .Foo}}\n", `a.tmpl:2:5: function "nofunc" not defined`},
+		{"{{.Foo}}\n{{if .Foo}}\n", "a.tmpl:3: unexpected EOF"},
+		{"あ{{.Foo \"x}}", "a.tmpl:1: unterminated quoted string"},
+	}
+	for _, c := range cases {
+		_, err := ParseTemplate("a.tmpl", c.text)
+		if err == nil {
+			t.Errorf("%q: should fail", c.text)
+			continue
+		}
+		if _, ok := err.(*TemplateError); !ok {
+			t.Errorf("%q: unexpected error type: %T", c.text, err)
+		}
+		if err.Error() != c.expected {
+			t.Errorf("%q: expected %q, actual %q", c.text, c.expected, err.Error())
+		}
+	}
+
+	_, err := ParseTemplate("a.tmpl", `{{ipAdd .Network 1}}`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExecuteTemplate(t *testing.T) {
+	t.Parallel()
+
+	tmpl, err := ParseTemplate("a.tmpl", "hello\n  {{.Foo.Bar}}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ExecuteTemplate(tmpl, map[string]int{"Foo": 1})
+	if err == nil {
+		t.Fatal("should fail")
+	}
+	e, ok := err.(*TemplateError)
+	if !ok {
+		t.Fatalf("unexpected error type: %T", err)
+	}
+	expected := "a.tmpl:2:9: at <.Foo.Bar>: can't evaluate field Bar in type int"
+	if e.Error() != expected {
+		t.Errorf("expected %q, actual %q", expected, e.Error())
+	}
+
+	out, err := ExecuteTemplate(tmpl, map[string]map[string]int{"Foo": {"Bar": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hello\n  1\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
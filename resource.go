@@ -22,7 +22,11 @@ type NetworkMenu struct {
 	NodeBase       net.IP
 	NodeRangeSize  int
 	NodeRangeMask  int
+	NodeIPPerNode  int
 	BMC            *net.IPNet
+	BMCBase        net.IP
+	BMCRangeSize   int
+	BMCRangeMask   int
 	ASNBase        int
 	Internet       *net.IPNet
 	CoreSpine      *net.IPNet
@@ -33,6 +37,99 @@ type NetworkMenu struct {
 	LoadBalancer   *net.IPNet
 	Ingress        *net.IPNet
 	Global         *net.IPNet
+	ShortenNames   bool
+
+	// Storage is nil unless the storage network is enabled
+	Storage          *net.IPNet
+	StorageRangeSize int
+	StorageBackbone  *net.IPNet
+	StorageCS        bool
+
+	// BGPAuth is nil unless BGP sessions are authenticated
+	BGPAuth *BGPAuthMenu
+
+	RoutingPolicy  RoutingPolicyMenu
+	RouterBackends RouterBackendsMenu
+}
+
+// RouterBackendsMenu represents the names of routing software of each tier
+type RouterBackendsMenu struct {
+	Core  string
+	Spine string
+	ToR   string
+}
+
+// RoutingPolicy represents BFD and BGP options of a tier of routers.
+// Zero values except BFDInterval mean the defaults of BIRD.
+type RoutingPolicy struct {
+	BFDInterval     int // milliseconds
+	BFDMultiplier   int
+	HoldTime        int // seconds
+	KeepaliveTime   int // seconds
+	GracefulRestart bool
+	ECMPLimit       int // 0 means no limit, and 1 disables ECMP
+	AddPaths        bool
+}
+
+var defaultRoutingPolicy = RoutingPolicy{
+	BFDInterval: 400,
+}
+
+// RoutingPolicyMenu represents routing policies of each tier.
+// Node is applied to the sessions between ToR switches and nodes.
+type RoutingPolicyMenu struct {
+	Core  RoutingPolicy
+	Spine RoutingPolicy
+	ToR   RoutingPolicy
+	Node  RoutingPolicy
+
+	// Filter is nil unless routes are filtered
+	Filter *RouteFilterMenu
+
+	// Communities is nil unless routes are tagged with communities
+	Communities *CommunitiesMenu
+}
+
+// CommunitiesMenu represents BGP communities to tag routes.
+// Routes from rack N are tagged with (ASN, RackBase + N).
+type CommunitiesMenu struct {
+	ASN          int
+	RackBase     int
+	Boot         int
+	CS           int
+	SS           int
+	Bastion      int
+	LoadBalancer int
+	Ingress      int
+	Global       int
+}
+
+var defaultCommunities = CommunitiesMenu{
+	RackBase:     1000,
+	Boot:         1,
+	CS:           2,
+	SS:           3,
+	Bastion:      101,
+	LoadBalancer: 102,
+	Ingress:      103,
+	Global:       104,
+}
+
+// RouteFilterMenu represents which prefixes nodes may announce.
+// ExposedRacks is the indices of racks whose nodes may announce exposed
+// addresses, and nil means all racks.
+type RouteFilterMenu struct {
+	ExposedRacks []int
+	NodePrefixes []*net.IPNet
+}
+
+// BGPAuthMenu represents how passwords of BGP sessions are given.
+// Secrets is keyed by the endpoint names of sessions, and the passwords
+// of the other sessions are derived from Seed.
+type BGPAuthMenu struct {
+	Seed    string
+	Secrets map[string]string
+	Nodes   bool
 }
 
 // InventoryMenu represents inventory settings to be written to the configuration file
@@ -44,25 +141,125 @@ type InventoryMenu struct {
 
 // RackMenu represents how many nodes each rack contains
 type RackMenu struct {
-	CS int
-	SS int
+	CS        int
+	SS        int
+	Boot      int
+	Spines    []int
+	ToRSpines map[int][]int
+	DownLinks []LinkMenu
+}
+
+// LinkMenu represents a link between a spine and a ToR switch
+type LinkMenu struct {
+	Spine int
+	ToR   int
+}
+
+// Connected returns true if the ToR in the rack is wired to the spine.
+// spineIdx is 0-origin, and torNumber is 1-origin.
+func (r RackMenu) Connected(spineIdx, torNumber int) bool {
+	spines := r.Spines
+	if s, ok := r.ToRSpines[torNumber]; ok {
+		spines = s
+	}
+	if len(spines) == 0 {
+		return true
+	}
+	for _, s := range spines {
+		if s == spineIdx+1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Down returns true if the link between the spine and the ToR is administratively down.
+// spineIdx is 0-origin, and torNumber is 1-origin.
+func (r RackMenu) Down(spineIdx, torNumber int) bool {
+	for _, l := range r.DownLinks {
+		if l.Spine == spineIdx+1 && l.ToR == torNumber {
+			return true
+		}
+	}
+	return false
 }
 
 // NodeMenu represents computing resources used by each type nodes
 type NodeMenu struct {
-	Type              NodeType
-	CPU               int
-	Memory            string
-	Image             string
-	Data              []string
-	UEFI              bool
-	CloudInitTemplate string
+	Type               NodeType
+	CPU                int
+	Memory             string
+	Image              string
+	Data               []string
+	UEFI               bool
+	CloudInitTemplate  string
+	IgnitionTemplate   string
+	VendorDataTemplate string
+	CloudInit          *CloudInitMenu
+	NICs               int
+	Bond               bool
+}
+
+// CloudInitMenu represents cloud-init settings merged into seeds of nodes
+type CloudInitMenu struct {
+	Users             []CloudInitUser
+	SSHAuthorizedKeys []string
+	Packages          []string
+	WriteFiles        []CloudInitFile
+	RunCmd            []string
+}
+
+// CloudInitUser represents a user created by cloud-init
+type CloudInitUser struct {
+	Name              string
+	Groups            []string
+	Shell             string
+	Sudo              string
+	LockPasswd        *bool
+	Passwd            string
+	SSHAuthorizedKeys []string
+}
+
+// CloudInitFile represents a file written by cloud-init
+type CloudInitFile struct {
+	Path        string
+	Content     string
+	Permissions string
+	Owner       string
+}
+
+// ExternalPeerMenu represents an external BGP peer of the core router
+type ExternalPeerMenu struct {
+	Name     string
+	ASN      int
+	Link     *net.IPNet
+	Prefixes []*net.IPNet
+}
+
+// OutputFileMenu represents a user-defined output file
+type OutputFileMenu struct {
+	Name     string
+	Template string
+	Output   string
+	Scope    string
+	Attach   bool
+}
+
+// GeneratorMenu represents settings of placemat-menu itself
+type GeneratorMenu struct {
+	// TemplateDir is the directory of templates overriding the embedded ones
+	TemplateDir string
+	// Plugins is the list of generator plugins
+	Plugins []string
 }
 
 // Menu is a top-level structure that summarizes the settings of each menus
 type Menu struct {
-	Network   *NetworkMenu
-	Inventory *InventoryMenu
-	Images    []*imageSpec
-	Nodes     []*NodeMenu
+	Network       *NetworkMenu
+	Inventory     *InventoryMenu
+	Images        []*imageSpec
+	Nodes         []*NodeMenu
+	ExternalPeers []*ExternalPeerMenu
+	OutputFiles   []*OutputFileMenu
+	Generator     *GeneratorMenu
 }
@@ -0,0 +1,531 @@
+package menu
+
+import (
+	"fmt"
+	"net"
+)
+
+// Names of neighbor groups
+const (
+	GroupCore     = "core"
+	GroupSpine    = "spine"
+	GroupToR      = "tor"
+	GroupNode     = "node"
+	GroupStorage  = "storage"
+	GroupExternal = "external"
+)
+
+// RouterModel is the routing configuration of a router independent of the
+// routing software.  Router backends render it into their configuration files.
+type RouterModel struct {
+	Name     string
+	ASN      int
+	RouterID net.IP
+	Policy   RoutingPolicy
+
+	// Connected is the networks of the interfaces announced to BGP neighbors
+	Connected []ConnectedNetwork
+
+	// BFD is the BFD settings per interfaces.  The last one matches all interfaces.
+	BFD []BFDSetting
+
+	// Statics is the static routes installed in StaticTable.
+	// StaticTable is empty for the main table.
+	Statics     []StaticRoute
+	StaticTable string
+
+	// Filters is the import filters referred by neighbor groups and neighbors.
+	// Matchers is the communities to define functions matching them.
+	Filters  []RouteFilter
+	Matchers []CommunityTag
+
+	Groups []NeighborGroup
+}
+
+// ConnectedNetwork is a network directly connected to an interface of the router
+type ConnectedNetwork struct {
+	Interface string
+	Network   *net.IPNet
+}
+
+// BFDSetting is the BFD setting of interfaces.  Interfaces are "*" for all interfaces.
+type BFDSetting struct {
+	Interfaces []string
+	Policy     RoutingPolicy
+}
+
+// StaticRoute is a static route.  Via is nil for blackhole routes.
+// Interface is set instead of Via for routes to the network connected to it.
+type StaticRoute struct {
+	Description string
+	Prefix      *net.IPNet
+	Via         net.IP
+	Interface   string
+}
+
+// RouteFilter is a filter to accept routes in Prefixes and tag them with communities.
+// Prefixes is a BIRD prefix set, or empty to accept all routes.
+// If DefaultOrigin is not 0, the default route is accepted only if it originates
+// from the AS, regardless of Prefixes.
+// Communities are added to all accepted routes.  FromTags and NetTags add communities
+// to routes whose neighbor addresses or prefixes match their sets, respectively.
+type RouteFilter struct {
+	Name          string
+	Prefixes      string
+	DefaultOrigin int
+	Communities   []string
+	FromTags      []CommunityTag
+	NetTags       []CommunityTag
+}
+
+// NeighborGroup is a group of BGP neighbors sharing the same options.
+// If RejectDefault is true, the default route is not imported from the neighbors.
+// If ExportStatics is true, the static routes of the router are announced to the neighbors.
+type NeighborGroup struct {
+	Name          string
+	Policy        RoutingPolicy
+	BFD           bool
+	ImportFilter  string
+	RejectDefault bool
+	ExportStatics bool
+	Table         string
+	Neighbors     []BGPNeighbor
+}
+
+// BGPNeighbor is a BGP neighbor.  Name is the name of the neighbor router or node.
+// ImportFilter overrides the filter of the group if not empty.
+type BGPNeighbor struct {
+	Name         string
+	Address      net.IP
+	ASN          int
+	Password     string
+	Disabled     bool
+	ImportFilter string
+}
+
+// Group returns the neighbor group of the name, or nil
+func (r *RouterModel) Group(name string) *NeighborGroup {
+	for i := range r.Groups {
+		if r.Groups[i].Name == name {
+			return &r.Groups[i]
+		}
+	}
+	return nil
+}
+
+// Filter returns the filter of the name, or nil
+func (r *RouterModel) Filter(name string) *RouteFilter {
+	for i := range r.Filters {
+		if r.Filters[i].Name == name {
+			return &r.Filters[i]
+		}
+	}
+	return nil
+}
+
+// Neighbor returns the neighbor of the name in any group, or nil
+func (r *RouterModel) Neighbor(name string) *BGPNeighbor {
+	for i := range r.Groups {
+		for j := range r.Groups[i].Neighbors {
+			if r.Groups[i].Neighbors[j].Name == name {
+				return &r.Groups[i].Neighbors[j]
+			}
+		}
+	}
+	return nil
+}
+
+// Interfaces returns the interface names of the connected networks
+func (r *RouterModel) Interfaces() []string {
+	var ifnames []string
+	for _, c := range r.Connected {
+		ifnames = append(ifnames, c.Interface)
+	}
+	return ifnames
+}
+
+func (t TemplateArgs) allBFD(p RoutingPolicy) BFDSetting {
+	return BFDSetting{Interfaces: []string{"*"}, Policy: p}
+}
+
+// CoreRouter returns the routing configuration of the core router
+func (t TemplateArgs) CoreRouter() *RouterModel {
+	rp := t.Network.RoutingPolicy
+	r := &RouterModel{
+		Name:     "core",
+		ASN:      t.Network.ASNCore,
+		RouterID: t.Core.InternetAddress.IP,
+		Policy:   rp.Core,
+		BFD:      []BFDSetting{t.allBFD(rp.Core)},
+		Statics: []StaticRoute{
+			{
+				Description: "default",
+				Prefix:      &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+				Via:         t.Network.Endpoints.Host.IP,
+			},
+		},
+	}
+	// BMC networks of racks are announced to spines, but not to external peers
+	for _, rack := range t.Racks {
+		if rack.BMCGatewayAddress == nil {
+			continue
+		}
+		r.Statics = append(r.Statics, StaticRoute{
+			Description: rack.Name + "-bmc",
+			Prefix:      rack.BMCNetwork,
+			Interface:   coreBMCInterface(rack.Index),
+		})
+	}
+	if rp.Communities != nil {
+		r.Matchers = t.CommunityMatchers()
+	}
+
+	spines := NeighborGroup{
+		Name:          GroupSpine,
+		Policy:        rp.Core,
+		BFD:           t.Network.RouterBackends.SpineBFD(),
+		ExportStatics: true,
+	}
+	if rp.Filter != nil {
+		r.Filters = append(r.Filters, RouteFilter{Name: "import_spine", Prefixes: t.CoreImportPrefixes()})
+		spines.ImportFilter = "import_spine"
+	}
+	for _, spine := range t.Spines {
+		spines.Neighbors = append(spines.Neighbors, BGPNeighbor{
+			Name:     spine.Name,
+			Address:  spine.CoreAddress.IP,
+			ASN:      t.Network.ASNSpine,
+			Password: t.BGPPassword(r.Name, spine.Name),
+		})
+	}
+
+	externals := NeighborGroup{
+		Name:          GroupExternal,
+		Policy:        rp.Core,
+		RejectDefault: rp.Filter != nil,
+	}
+	for _, peer := range t.ExternalPeers {
+		externals.Neighbors = append(externals.Neighbors, BGPNeighbor{
+			Name:     peer.Name,
+			Address:  peer.Address.IP,
+			ASN:      peer.ASN,
+			Password: t.BGPPassword(r.Name, peer.Name),
+		})
+	}
+
+	r.Groups = []NeighborGroup{spines, externals}
+	return r
+}
+
+// SpineRouter returns the routing configuration of the spine switch
+func (t TemplateArgs) SpineRouter(spineIdx int) *RouterModel {
+	rp := t.Network.RoutingPolicy
+	spine := t.Spines[spineIdx]
+	coreAddress := t.Core.SpineAddresses[spineIdx].IP
+	exposed := t.Network.Exposed
+	r := &RouterModel{
+		Name:     spine.Name,
+		ASN:      t.Network.ASNSpine,
+		RouterID: spine.CoreAddress.IP,
+		Policy:   rp.Spine,
+		BFD:      []BFDSetting{t.allBFD(rp.Spine)},
+		Statics: []StaticRoute{
+			{Description: "LoadBalancer", Prefix: exposed.LoadBalancer, Via: coreAddress},
+			{Description: "Bastion", Prefix: exposed.Bastion, Via: coreAddress},
+			{Description: "Ingress", Prefix: exposed.Ingress, Via: coreAddress},
+			{Description: "Global", Prefix: exposed.Global, Via: coreAddress},
+		},
+		StaticTable: "outertab",
+	}
+	if rp.Communities != nil {
+		r.Matchers = t.CommunityMatchers()
+	}
+
+	tors := NeighborGroup{
+		Name:   GroupToR,
+		Policy: rp.Spine,
+		BFD:    true,
+	}
+	for _, rack := range t.Racks {
+		var filter string
+		if rp.Filter != nil || rp.Communities != nil {
+			filter = "import_" + rack.Name
+			r.Filters = append(r.Filters, t.rackFilter(filter, rack.Index))
+		}
+		for _, tor := range rack.ToRs() {
+			if !tor.Connected(spineIdx) {
+				continue
+			}
+			tors.Neighbors = append(tors.Neighbors, BGPNeighbor{
+				Name:         tor.Name,
+				Address:      tor.SpineAddresses[spineIdx].IP,
+				ASN:          rack.ASN,
+				Password:     t.BGPPassword(r.Name, tor.Name),
+				Disabled:     tor.Down(spineIdx),
+				ImportFilter: filter,
+			})
+		}
+	}
+
+	core := NeighborGroup{
+		Name:          GroupCore,
+		Policy:        rp.Spine,
+		BFD:           t.Network.RouterBackends.CoreBFD(),
+		ExportStatics: true,
+		Table:         r.StaticTable,
+		Neighbors: []BGPNeighbor{
+			{
+				Name:     "core",
+				Address:  coreAddress,
+				ASN:      t.Network.ASNCore,
+				Password: t.BGPPassword("core", r.Name),
+			},
+		},
+	}
+
+	r.Groups = []NeighborGroup{tors, core}
+	return r
+}
+
+// rackFilter returns the filter of routes from the rack
+func (t TemplateArgs) rackFilter(name string, rackIdx int) RouteFilter {
+	rp := t.Network.RoutingPolicy
+	f := RouteFilter{Name: name}
+	if rp.Filter != nil {
+		f.Prefixes = t.RackImportPrefixes(rackIdx)
+	}
+	if rp.Communities != nil {
+		f.Communities = []string{t.RackCommunity(rackIdx)}
+		f.NetTags = t.ExposedCommunities()
+	}
+	return f
+}
+
+// ToRRouter returns the routing configuration of the ToR switch.
+// torNumber is 1 or 2.
+func (t TemplateArgs) ToRRouter(rackIdx, torNumber int) *RouterModel {
+	rp := t.Network.RoutingPolicy
+	rack := t.Racks[rackIdx]
+	tor := rack.ToRs()[torNumber-1]
+	r := &RouterModel{
+		Name:     tor.Name,
+		ASN:      rack.ASN,
+		RouterID: tor.NodeAddress.IP,
+		Policy:   rp.ToR,
+		Connected: []ConnectedNetwork{
+			{Interface: tor.NodeInterface, Network: tor.NodeNetwork()},
+		},
+	}
+	if tor.BondInterface != "" {
+		r.Connected = append(r.Connected, ConnectedNetwork{Interface: tor.BondInterface, Network: tor.BondNetwork()})
+	}
+	if !rp.Node.SameBFD(rp.ToR) {
+		r.BFD = append(r.BFD, BFDSetting{Interfaces: r.Interfaces(), Policy: rp.Node})
+	}
+	r.BFD = append(r.BFD, t.allBFD(rp.ToR))
+
+	spines := NeighborGroup{
+		Name:   GroupSpine,
+		Policy: rp.ToR,
+		BFD:    t.Network.RouterBackends.SpineBFD(),
+	}
+	if rp.Filter != nil {
+		f := RouteFilter{
+			Name:          "import_spine",
+			Prefixes:      t.ToRImportPrefixes(),
+			DefaultOrigin: t.Network.ASNCore,
+		}
+		r.Filters = append(r.Filters, f)
+		spines.ImportFilter = f.Name
+	}
+	for spineIdx, spine := range t.Spines {
+		if !tor.Connected(spineIdx) {
+			continue
+		}
+		address := spine.ToR1Address(rackIdx)
+		if torNumber == 2 {
+			address = spine.ToR2Address(rackIdx)
+		}
+		spines.Neighbors = append(spines.Neighbors, BGPNeighbor{
+			Name:     spine.Name,
+			Address:  address.IP,
+			ASN:      t.Network.ASNSpine,
+			Password: t.BGPPassword(r.Name, spine.Name),
+			Disabled: tor.Down(spineIdx),
+		})
+	}
+
+	nodes := NeighborGroup{
+		Name:   GroupNode,
+		Policy: rp.Node,
+		BFD:    true,
+	}
+	if rp.Filter != nil || rp.Communities != nil {
+		f := RouteFilter{Name: "import_node"}
+		if rp.Filter != nil {
+			f.Prefixes = t.NodeImportPrefixes(rackIdx)
+		}
+		if rp.Communities != nil {
+			f.Communities = []string{t.RackCommunity(rackIdx)}
+			f.FromTags = t.NodeTypeCommunities(rackIdx)
+			f.NetTags = t.ExposedCommunities()
+		}
+		r.Filters = append(r.Filters, f)
+		nodes.ImportFilter = f.Name
+	}
+	for _, node := range rack.Nodes() {
+		address := node.PeerAddress(torNumber)
+		if address == nil {
+			continue
+		}
+		nodes.Neighbors = append(nodes.Neighbors, BGPNeighbor{
+			Name:     node.Fullname,
+			Address:  address.IP,
+			ASN:      rack.ASN,
+			Password: t.BGPPassword(r.Name, node.Fullname),
+		})
+	}
+
+	r.Groups = []NeighborGroup{spines, nodes}
+	return r
+}
+
+// StorageToRRouter returns the routing configuration of the storage ToR switch
+func (t TemplateArgs) StorageToRRouter(rackIdx int) *RouterModel {
+	rp := t.Network.RoutingPolicy
+	rack := t.Racks[rackIdx]
+	stor := rack.StorageToR
+	r := &RouterModel{
+		Name:     stor.Name,
+		ASN:      rack.ASN,
+		RouterID: stor.BackboneAddress.IP,
+		Policy:   rp.ToR,
+		Connected: []ConnectedNetwork{
+			{Interface: stor.NodeInterface, Network: stor.NodeNetwork},
+		},
+		BFD: []BFDSetting{t.allBFD(rp.ToR)},
+	}
+
+	others := NeighborGroup{
+		Name:   GroupStorage,
+		Policy: rp.ToR,
+		BFD:    true,
+	}
+	if rp.Filter != nil {
+		f := RouteFilter{Name: "import_storage", Prefixes: t.StorageImportPrefixes(rackIdx)}
+		r.Filters = append(r.Filters, f)
+		others.ImportFilter = f.Name
+	}
+	for _, other := range t.Racks {
+		if other.Index == rackIdx {
+			continue
+		}
+		others.Neighbors = append(others.Neighbors, BGPNeighbor{
+			Name:     other.StorageToR.Name,
+			Address:  other.StorageToR.BackboneAddress.IP,
+			ASN:      other.ASN,
+			Password: t.BGPPassword(r.Name, other.StorageToR.Name),
+		})
+	}
+
+	r.Groups = []NeighborGroup{others}
+	return r
+}
+
+// ExternalPeerRouter returns the routing configuration of the external peer.
+// The peer announces its prefixes to the core router.
+func (t TemplateArgs) ExternalPeerRouter(peerIdx int) *RouterModel {
+	rp := t.Network.RoutingPolicy
+	peer := t.ExternalPeers[peerIdx]
+	r := &RouterModel{
+		Name:     peer.Name,
+		ASN:      peer.ASN,
+		RouterID: peer.Address.IP,
+		Policy:   rp.Core,
+	}
+	for _, prefix := range peer.Prefixes {
+		r.Statics = append(r.Statics, StaticRoute{Description: "announced", Prefix: prefix})
+	}
+
+	core := NeighborGroup{
+		Name:   GroupCore,
+		Policy: rp.Core,
+		Neighbors: []BGPNeighbor{
+			{
+				Name:     "core",
+				Address:  peer.CoreAddress.IP,
+				ASN:      t.Network.ASNCore,
+				Password: t.BGPPassword("core", peer.Name),
+			},
+		},
+	}
+
+	r.Groups = []NeighborGroup{core}
+	return r
+}
+
+// NodeRouter returns the routing configuration of the node in the rack.
+// Nodes announce their node0 addresses to the ToR switches by iBGP.
+func (t TemplateArgs) NodeRouter(rackIdx int, node Node) *RouterModel {
+	rp := t.Network.RoutingPolicy
+	rack := t.Racks[rackIdx]
+	r := &RouterModel{
+		Name:     node.Fullname,
+		ASN:      rack.ASN,
+		RouterID: node.Node0Address.IP,
+		Policy:   rp.Node,
+		Connected: []ConnectedNetwork{
+			{Interface: nodeDummyInterface, Network: node.Node0Address},
+		},
+		BFD: []BFDSetting{t.allBFD(rp.Node)},
+	}
+
+	tors := NeighborGroup{
+		Name:   GroupToR,
+		Policy: rp.Node,
+		BFD:    true,
+	}
+	for _, tor := range []struct {
+		name    string
+		address *net.IPNet
+	}{
+		{rack.ToR1.Name, node.ToR1Address},
+		{rack.ToR2.Name, node.ToR2Address},
+	} {
+		if tor.address == nil {
+			continue
+		}
+		tors.Neighbors = append(tors.Neighbors, BGPNeighbor{
+			Name:     tor.name,
+			Address:  tor.address.IP,
+			ASN:      rack.ASN,
+			Password: t.BGPPassword(tor.name, node.Fullname),
+		})
+	}
+
+	r.Groups = []NeighborGroup{tors}
+	return r
+}
+
+// validateRouterModel checks the consistency of the routing configuration
+func validateRouterModel(r *RouterModel) error {
+	seen := make(map[string]bool)
+	for _, g := range r.Groups {
+		if g.ImportFilter != "" && r.Filter(g.ImportFilter) == nil {
+			return fmt.Errorf("%s: undefined filter %s for %s", r.Name, g.ImportFilter, g.Name)
+		}
+		for _, n := range g.Neighbors {
+			if seen[n.Name] {
+				return fmt.Errorf("%s: duplicate neighbor %s", r.Name, n.Name)
+			}
+			seen[n.Name] = true
+			if n.Address == nil {
+				return fmt.Errorf("%s: no address for neighbor %s", r.Name, n.Name)
+			}
+			if n.ImportFilter != "" && r.Filter(n.ImportFilter) == nil {
+				return fmt.Errorf("%s: undefined filter %s for %s", r.Name, n.ImportFilter, n.Name)
+			}
+		}
+	}
+	return nil
+}
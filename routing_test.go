@@ -0,0 +1,213 @@
+package menu
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRouterModel(t *testing.T) {
+	t.Parallel()
+
+	racks := []RackMenu{
+		{CS: 2, SS: 1, Boot: 1},
+		{CS: 1, Boot: 1, Spines: []int{2}, DownLinks: []LinkMenu{{Spine: 2, ToR: 1}}},
+	}
+	m := testMenu(2, racks)
+	m.Network.RoutingPolicy.Filter = &RouteFilterMenu{}
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core := ta.CoreRouter()
+	spines := core.Group(GroupSpine)
+	if len(spines.Neighbors) != 2 {
+		t.Fatalf("core must have a neighbor for each spine: %v", spines.Neighbors)
+	}
+	for i, n := range spines.Neighbors {
+		spine := ta.Spines[i]
+		if n.Name != spine.Name || !n.Address.Equal(spine.CoreAddress.IP) || n.ASN != ta.Network.ASNSpine {
+			t.Errorf("unexpected neighbor of core: %v", n)
+		}
+	}
+	if spines.ImportFilter != "import_spine" || core.Filter("import_spine") == nil {
+		t.Error("core must filter routes from spines")
+	}
+	if !core.Group(GroupExternal).RejectDefault {
+		t.Error("core must not import the default route from external peers")
+	}
+
+	tor := ta.ToRRouter(0, 1)
+	if tor.Name != "rack0-tor1" || tor.ASN != ta.Racks[0].ASN {
+		t.Errorf("unexpected ToR: %s %d", tor.Name, tor.ASN)
+	}
+	for i, spine := range ta.Spines {
+		n := tor.Neighbor(spine.Name)
+		if n == nil {
+			t.Errorf("rack0-tor1 must have a neighbor for %s", spine.Name)
+			continue
+		}
+		if !n.Address.Equal(spine.ToR1Address(0).IP) || n.ASN != ta.Network.ASNSpine {
+			t.Errorf("unexpected neighbor of rack0-tor1: %v", n)
+		}
+		if n.Disabled {
+			t.Errorf("neighbor %d of rack0-tor1 must not be disabled", i)
+		}
+	}
+	nodes := tor.Group(GroupNode)
+	if len(nodes.Neighbors) != 4 {
+		t.Fatalf("rack0-tor1 must have a neighbor for each node: %v", nodes.Neighbors)
+	}
+	if nodes.Neighbors[0].Name != "boot-0" || nodes.Neighbors[3].Name != "rack0-ss1" {
+		t.Errorf("unexpected nodes of rack0-tor1: %v", nodes.Neighbors)
+	}
+	if nodes.ImportFilter != "import_node" {
+		t.Errorf("unexpected filter for nodes: %s", nodes.ImportFilter)
+	}
+	if len(tor.Connected) != 1 || tor.Connected[0].Interface != ta.Racks[0].ToR1.NodeInterface {
+		t.Errorf("unexpected connected networks: %v", tor.Connected)
+	}
+
+	tor = ta.ToRRouter(1, 1)
+	if tor.Neighbor("spine1") != nil {
+		t.Error("rack1-tor1 must not have a neighbor for spine1")
+	}
+	if n := tor.Neighbor("spine2"); n == nil || !n.Disabled {
+		t.Error("the neighbor for spine2 of rack1-tor1 must be disabled")
+	}
+	if n := ta.ToRRouter(1, 2).Neighbor("spine2"); n == nil || n.Disabled {
+		t.Error("the neighbor for spine2 of rack1-tor2 must be enabled")
+	}
+
+	spine := ta.SpineRouter(0)
+	tors := spine.Group(GroupToR)
+	if len(tors.Neighbors) != 2 {
+		t.Fatalf("spine1 must have neighbors only for rack0: %v", tors.Neighbors)
+	}
+	for _, n := range tors.Neighbors {
+		if n.ImportFilter != "import_rack0" {
+			t.Errorf("unexpected filter for %s: %s", n.Name, n.ImportFilter)
+		}
+	}
+	c := spine.Group(GroupCore)
+	if len(c.Neighbors) != 1 || !c.Neighbors[0].Address.Equal(ta.Core.SpineAddresses[0].IP) {
+		t.Errorf("unexpected core neighbor of spine1: %v", c.Neighbors)
+	}
+	if c.Table != spine.StaticTable || len(spine.Statics) != 4 {
+		t.Errorf("exposed routes must be in the table of the core session: %s %v", c.Table, spine.Statics)
+	}
+
+	for _, r := range []*RouterModel{core, spine, ta.SpineRouter(1), ta.ToRRouter(0, 2), tor} {
+		err = validateRouterModel(r)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	spine.Group(GroupToR).Neighbors[0].ImportFilter = "import_rack9"
+	err = validateRouterModel(spine)
+	if err == nil {
+		t.Error("undefined filter must be an error")
+	}
+}
+
+func TestExternalPeerRouter(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, Boot: 1}})
+	m.ExternalPeers = []*ExternalPeerMenu{
+		{Name: "isp1", ASN: 65001, Link: mustParseCIDR("10.0.5.0/30"), Prefixes: []*net.IPNet{mustParseCIDR("192.0.2.0/24")}},
+	}
+	m.Network.BGPAuth = &BGPAuthMenu{Seed: "seed"}
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core := ta.CoreRouter()
+	for peerIdx, peer := range ta.ExternalPeers {
+		r := ta.ExternalPeerRouter(peerIdx)
+		err = validateRouterModel(r)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(r.Statics) != len(peer.Prefixes) {
+			t.Errorf("%s must announce its prefixes: %v", peer.Name, r.Statics)
+		}
+
+		n := r.Neighbor("core")
+		if n == nil {
+			t.Fatalf("%s must have a neighbor for core", peer.Name)
+		}
+		if n.ASN != ta.Network.ASNCore || !n.Address.Equal(peer.CoreAddress.IP) {
+			t.Errorf("unexpected neighbor of %s: %v", peer.Name, n)
+		}
+		c := core.Neighbor(peer.Name)
+		if c == nil || c.Password == "" || c.Password != n.Password {
+			t.Errorf("passwords of both ends must be the same: %v %v", c, n)
+		}
+	}
+}
+
+func TestRouterModelBFD(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(1, []RackMenu{{CS: 1, Boot: 1}})
+	m.Network.RoutingPolicy.ToR = defaultRoutingPolicy
+	m.Network.RoutingPolicy.Node = RoutingPolicy{BFDInterval: 100}
+	m.Network.RouterBackends = RouterBackendsMenu{Core: backendBIRD, Spine: backendGoBGP, ToR: backendBIRD}
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tor := ta.ToRRouter(0, 1)
+	if len(tor.BFD) != 2 || tor.BFD[0].Policy.BFDInterval != 100 || tor.BFD[1].Interfaces[0] != "*" {
+		t.Errorf("unexpected BFD settings: %v", tor.BFD)
+	}
+	if tor.Group(GroupSpine).BFD || !tor.Group(GroupNode).BFD {
+		t.Error("BFD must be disabled only for the sessions with spines")
+	}
+	if ta.CoreRouter().Group(GroupSpine).BFD {
+		t.Error("BFD must be disabled for the sessions with spines")
+	}
+}
+
+func TestNodeRouter(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, SS: 1, Boot: 1}})
+	m.Nodes[2].NICs = 1
+	m.Network.BGPAuth = &BGPAuthMenu{Seed: "seed", Nodes: true}
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rack := ta.Racks[0]
+
+	cs := ta.NodeRouter(0, rack.CSList[0])
+	if cs.Name != "rack0-cs1" || cs.ASN != rack.ASN || !cs.RouterID.Equal(rack.CSList[0].Node0Address.IP) {
+		t.Errorf("unexpected node: %s %d %v", cs.Name, cs.ASN, cs.RouterID)
+	}
+	if len(cs.Connected) != 1 || cs.Connected[0].Interface != "node0" {
+		t.Errorf("node must announce node0 address: %v", cs.Connected)
+	}
+	for _, tor := range rack.ToRs() {
+		n := cs.Neighbor(tor.Name)
+		if n == nil {
+			t.Errorf("rack0-cs1 must have a neighbor for %s", tor.Name)
+			continue
+		}
+		if !n.Address.Equal(tor.NodeAddress.IP) || n.ASN != rack.ASN {
+			t.Errorf("unexpected neighbor of rack0-cs1: %v", n)
+		}
+		if n.Password == "" || n.Password != ta.BGPPassword(tor.Name, "rack0-cs1") {
+			t.Errorf("password for %s must be the same as the ToR switch", tor.Name)
+		}
+	}
+
+	ss := ta.NodeRouter(0, rack.SSList[0])
+	if len(ss.Group(GroupToR).Neighbors) != 1 || ss.Neighbor("rack0-tor2") != nil {
+		t.Errorf("ss with one NIC must peer only with ToR-1: %v", ss.Group(GroupToR).Neighbors)
+	}
+}
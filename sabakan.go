@@ -1,55 +1,40 @@
 package menu
 
 import (
+	"bytes"
 	"encoding/json"
-	"io"
-	"os"
-	"path/filepath"
+	"io/ioutil"
 
 	"github.com/cybozu-go/sabakan"
 )
 
-func copyIPAMConfig(src, dst string) error {
-	s, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer s.Close()
-
-	d, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer d.Close()
-
-	_, err = io.Copy(d, s)
-	return err
+// SabakanFile is a configuration file for sabakan
+type SabakanFile struct {
+	Name    string
+	Content []byte
 }
 
-func exportJSON(dst string, data interface{}) error {
-	f, err := os.Create(dst)
+func marshalJSON(data interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(data)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
-
-	encoder := json.NewEncoder(f)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(data)
+	return buf.Bytes(), nil
 }
 
-func exportDHCPConfig(dst string) error {
-	config := sabakan.DHCPConfig{
+func dhcpConfig() sabakan.DHCPConfig {
+	return sabakan.DHCPConfig{
 		GatewayOffset: offsetNodenetToR,
 		LeaseMinutes:  60,
 	}
-
-	return exportJSON(dst, config)
 }
 
-func sabakanMachine(serial string, rack int, role string) sabakan.MachineSpec {
+func sabakanMachine(node Node, rack int, role string) sabakan.MachineSpec {
 	return sabakan.MachineSpec{
-		Serial: serial,
+		Serial: node.Serial,
 		Labels: map[string]string{
 			"product":    "vm",
 			"datacenter": "dc1",
@@ -57,39 +42,48 @@ func sabakanMachine(serial string, rack int, role string) sabakan.MachineSpec {
 		Rack: uint(rack),
 		Role: role,
 		BMC: sabakan.MachineBMC{
+			IPv4: node.BMCAddress.IP.String(),
 			Type: "IPMI-2.0",
 		},
 	}
 }
 
-func exportMachinesJSON(dst string, ta *TemplateArgs) error {
+func sabakanMachines(ta *TemplateArgs) []sabakan.MachineSpec {
 	var ms []sabakan.MachineSpec
 
 	for _, rack := range ta.Racks {
-		ms = append(ms, sabakanMachine(rack.BootNode.Serial, rack.Index, "boot"))
+		for _, boot := range rack.BootNodes {
+			ms = append(ms, sabakanMachine(boot.Node, rack.Index, "boot"))
+		}
 
 		for _, cs := range rack.CSList {
-			ms = append(ms, sabakanMachine(cs.Serial, rack.Index, "worker"))
+			ms = append(ms, sabakanMachine(cs, rack.Index, "worker"))
 		}
 		for _, ss := range rack.SSList {
-			ms = append(ms, sabakanMachine(ss.Serial, rack.Index, "worker"))
+			ms = append(ms, sabakanMachine(ss, rack.Index, "worker"))
 		}
 	}
 
-	return exportJSON(dst, ms)
+	return ms
 }
 
-// ExportSabakanData exports configuration files for sabakan
-func ExportSabakanData(dir string, m *Menu, ta *TemplateArgs) error {
-	err := copyIPAMConfig(m.Network.IPAMConfigFile, filepath.Join(dir, "ipam.json"))
+// SabakanData returns the configuration files for sabakan
+func SabakanData(m *Menu, ta *TemplateArgs) ([]SabakanFile, error) {
+	ipam, err := ioutil.ReadFile(m.Network.IPAMConfigFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	err = exportDHCPConfig(filepath.Join(dir, "dhcp.json"))
+	dhcp, err := marshalJSON(dhcpConfig())
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return exportMachinesJSON(filepath.Join(dir, "machines.json"), ta)
+	machines, err := marshalJSON(sabakanMachines(ta))
+	if err != nil {
+		return nil, err
+	}
+	return []SabakanFile{
+		{Name: "ipam.json", Content: ipam},
+		{Name: "dhcp.json", Content: dhcp},
+		{Name: "machines.json", Content: machines},
+	}, nil
 }
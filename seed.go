@@ -0,0 +1,184 @@
+package menu
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const cloudConfigHeader = "#cloud-config"
+
+// cloudConfigItemKeys are the functions to identify items of lists in cloud-config.
+// Items of lists not here, e.g. runcmd, are always appended.
+var cloudConfigItemKeys = map[string]func(interface{}) string{
+	"users":               mapItemKey("name"),
+	"write_files":         mapItemKey("path"),
+	"packages":            packageName,
+	"ssh_authorized_keys": func(item interface{}) string { return fmt.Sprint(item) },
+}
+
+// mapItemKey returns a function which returns the value of key in a mapping,
+// or the item itself if it is not a mapping, e.g. "default" in users.
+func mapItemKey(key string) func(interface{}) string {
+	return func(item interface{}) string {
+		switch m := item.(type) {
+		case yaml.MapSlice:
+			for _, i := range m {
+				if i.Key == key {
+					return fmt.Sprint(i.Value)
+				}
+			}
+		case map[interface{}]interface{}:
+			return fmt.Sprint(m[key])
+		}
+		return fmt.Sprint(item)
+	}
+}
+
+// packageName returns the name of a package given as NAME or [NAME, VERSION]
+func packageName(item interface{}) string {
+	if l, ok := item.([]interface{}); ok && len(l) > 0 {
+		return fmt.Sprint(l[0])
+	}
+	return fmt.Sprint(item)
+}
+
+func (u CloudInitUser) cloudConfig() yaml.MapSlice {
+	user := yaml.MapSlice{{Key: "name", Value: u.Name}}
+	if len(u.Groups) > 0 {
+		user = append(user, yaml.MapItem{Key: "groups", Value: u.Groups})
+	}
+	if u.Shell != "" {
+		user = append(user, yaml.MapItem{Key: "shell", Value: u.Shell})
+	}
+	if u.Sudo != "" {
+		user = append(user, yaml.MapItem{Key: "sudo", Value: u.Sudo})
+	}
+	if u.LockPasswd != nil {
+		user = append(user, yaml.MapItem{Key: "lock_passwd", Value: *u.LockPasswd})
+	}
+	if u.Passwd != "" {
+		user = append(user, yaml.MapItem{Key: "passwd", Value: u.Passwd})
+	}
+	if len(u.SSHAuthorizedKeys) > 0 {
+		user = append(user, yaml.MapItem{Key: "ssh_authorized_keys", Value: u.SSHAuthorizedKeys})
+	}
+	return user
+}
+
+func (f CloudInitFile) cloudConfig() yaml.MapSlice {
+	file := yaml.MapSlice{
+		{Key: "path", Value: f.Path},
+		{Key: "content", Value: f.Content},
+	}
+	if f.Permissions != "" {
+		file = append(file, yaml.MapItem{Key: "permissions", Value: f.Permissions})
+	}
+	if f.Owner != "" {
+		file = append(file, yaml.MapItem{Key: "owner", Value: f.Owner})
+	}
+	return file
+}
+
+func stringList(l []string) []interface{} {
+	items := make([]interface{}, len(l))
+	for i, s := range l {
+		items[i] = s
+	}
+	return items
+}
+
+// cloudConfig returns the settings in cloud-config format
+func (ci *CloudInitMenu) cloudConfig() yaml.MapSlice {
+	var cc yaml.MapSlice
+	if len(ci.Users) > 0 {
+		var users []interface{}
+		for _, u := range ci.Users {
+			users = append(users, u.cloudConfig())
+		}
+		cc = append(cc, yaml.MapItem{Key: "users", Value: users})
+	}
+	if len(ci.SSHAuthorizedKeys) > 0 {
+		cc = append(cc, yaml.MapItem{Key: "ssh_authorized_keys", Value: stringList(ci.SSHAuthorizedKeys)})
+	}
+	if len(ci.Packages) > 0 {
+		cc = append(cc, yaml.MapItem{Key: "packages", Value: stringList(ci.Packages)})
+	}
+	if len(ci.WriteFiles) > 0 {
+		var files []interface{}
+		for _, f := range ci.WriteFiles {
+			files = append(files, f.cloudConfig())
+		}
+		cc = append(cc, yaml.MapItem{Key: "write_files", Value: files})
+	}
+	if len(ci.RunCmd) > 0 {
+		cc = append(cc, yaml.MapItem{Key: "runcmd", Value: stringList(ci.RunCmd)})
+	}
+	return cc
+}
+
+// mergeList merges items of the list in the menu into the list in the template.
+// The template wins if its value is not a list, or items have the same identity.
+func mergeList(key string, tmpl, menu interface{}) interface{} {
+	tmplList, ok := tmpl.([]interface{})
+	if !ok {
+		return tmpl
+	}
+	menuList := menu.([]interface{})
+
+	itemKey := cloudConfigItemKeys[key]
+	if itemKey == nil {
+		return append(tmplList, menuList...)
+	}
+	seen := make(map[string]bool)
+	for _, item := range tmplList {
+		seen[itemKey(item)] = true
+	}
+	for _, item := range menuList {
+		if !seen[itemKey(item)] {
+			tmplList = append(tmplList, item)
+		}
+	}
+	return tmplList
+}
+
+// MergeCloudConfig merges ci into cloud-config rendered from the template, and
+// returns the result.  tmpl may be empty if the node has no template.
+// The template must start with "#cloud-config".  Comments in it are not kept.
+func MergeCloudConfig(tmpl []byte, ci *CloudInitMenu) ([]byte, error) {
+	var cc yaml.MapSlice
+	if len(tmpl) > 0 {
+		if !bytes.HasPrefix(tmpl, []byte(cloudConfigHeader)) {
+			return nil, errors.New("cloud-init template must start with " + cloudConfigHeader + " to be merged with cloud-init")
+		}
+		err := yaml.Unmarshal(tmpl, &cc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, item := range ci.cloudConfig() {
+		found := false
+		for i := range cc {
+			if cc[i].Key == item.Key {
+				cc[i].Value = mergeList(item.Key.(string), cc[i].Value, item.Value)
+				found = true
+				break
+			}
+		}
+		if !found {
+			cc = append(cc, item)
+		}
+	}
+
+	if len(cc) == 0 {
+		return []byte(cloudConfigHeader + "\n"), nil
+	}
+	data, err := yaml.Marshal(cc)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(cloudConfigHeader+"\n"), data...), nil
+}
@@ -0,0 +1,115 @@
+package menu
+
+import (
+	"testing"
+)
+
+func TestMergeCloudConfig(t *testing.T) {
+	t.Parallel()
+
+	ci := &CloudInitMenu{
+		Users: []CloudInitUser{
+			{Name: "cybozu", Groups: []string{"sudo"}},
+			{Name: "neco", Shell: "/bin/bash"},
+		},
+		SSHAuthorizedKeys: []string{"key1", "key2"},
+		Packages:          []string{"chrony", "jq"},
+		WriteFiles: []CloudInitFile{
+			{Path: "/etc/motd", Content: "menu"},
+			{Path: "/etc/issue", Content: "menu", Permissions: "0600"},
+		},
+		RunCmd: []string{"echo menu"},
+	}
+
+	tmpl := `#cloud-config
+hostname: boot-0
+users:
+- default
+- name: cybozu
+  groups: [adm]
+packages:
+- [jq, "1.5"]
+write_files:
+- path: /etc/motd
+  content: template
+runcmd:
+- ["echo", "template"]
+ssh_authorized_keys: key1
+`
+	expected := `#cloud-config
+hostname: boot-0
+users:
+- default
+- name: cybozu
+  groups:
+  - adm
+- name: neco
+  shell: /bin/bash
+packages:
+- - jq
+  - "1.5"
+- chrony
+write_files:
+- path: /etc/motd
+  content: template
+- path: /etc/issue
+  content: menu
+  permissions: "0600"
+runcmd:
+- - echo
+  - template
+- echo menu
+ssh_authorized_keys: key1
+`
+	actual, err := MergeCloudConfig([]byte(tmpl), ci)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != expected {
+		t.Errorf("unexpected merged cloud-config:\n%s", actual)
+	}
+
+	expected = `#cloud-config
+packages:
+- chrony
+`
+	actual, err = MergeCloudConfig(nil, &CloudInitMenu{Packages: []string{"chrony"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != expected {
+		t.Errorf("unexpected cloud-config without template:\n%s", actual)
+	}
+
+	_, err = MergeCloudConfig([]byte("#!/bin/sh\necho hello\n"), ci)
+	if err == nil {
+		t.Error("non cloud-config template should not be merged")
+	}
+}
+
+func TestSeedVolumes(t *testing.T) {
+	t.Parallel()
+
+	m := testMenu(2, []RackMenu{{CS: 1, SS: 1, Boot: 1}})
+	m.Nodes[1].CloudInit = &CloudInitMenu{Packages: []string{"chrony"}}
+	ta, err := ToTemplateArgs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := generateCluster(ta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range cluster.nodes {
+		attached := false
+		for _, v := range n.Volumes {
+			if v.Kind == "localds" && v.UserData == "seed_"+n.Name+".yml" && v.NetworkConfig == "network_"+n.Name+".yml" {
+				attached = true
+			}
+		}
+		if attached != (n.Name == "rack0-cs1") {
+			t.Errorf("seed must be attached only to rack0-cs1: %s", n.Name)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net"
 
 	"github.com/cybozu-go/netutil"
+	"github.com/cybozu-go/placemat"
 )
 
 const (
@@ -21,9 +22,9 @@ const (
 	offsetOperationCore      = 1
 	offsetOperationOperation = 2
 
-	offsetNodenetToR     = 1
-	offsetNodenetBoot    = 3
-	offsetNodenetServers = 4
+	offsetNodenetToR  = 1
+	offsetNodenetToR2 = 2 // ToR-2 address in node1 network shared by bonded nodes
+	offsetNodenetBoot = 3 // boot servers come first, then cs and ss
 
 	offsetASNCore     = -3
 	offsetASNExternal = -2
@@ -31,6 +32,14 @@ const (
 
 	offsetBMCHost = 1
 	offsetBMCCore = 2
+
+	offsetBMCRackCore = 1 // core address in BMC network of each rack
+
+	offsetStorageToR      = 1
+	offsetBackboneStorage = 1
+
+	offsetExternalPeerCore = 1
+	offsetExternalPeerPeer = 2
 )
 
 // Rack is template args for rack
@@ -42,12 +51,39 @@ type Rack struct {
 	NodeNetworkPrefixSize int
 	ToR1                  ToR
 	ToR2                  ToR
-	BootNode              BootNodeEntity
+	BootNodes             []BootNodeEntity
 	CSList                []Node
 	SSList                []Node
-	node0Network          *net.IPNet
-	node1Network          *net.IPNet
-	node2Network          *net.IPNet
+
+	// BMCNetwork is the network which BMCs in the rack belong to.
+	// BMCGatewayAddress is the core address in BMCNetwork, or nil if all
+	// BMCs share one network.
+	BMCNetwork        *net.IPNet
+	BMCGatewayAddress *net.IPNet
+
+	// StorageToR is nil unless the storage network is enabled
+	StorageToR *StorageToR
+
+	bmcBase       net.IP
+	bmcPrefixSize int // prefix length of BMC addresses
+	node0Network  *net.IPNet
+	node1Network  *net.IPNet
+	node2Network  *net.IPNet // nil if node-ip-per-node is 2
+}
+
+// ToRs returns the ToR switches in the rack
+func (r Rack) ToRs() []ToR {
+	return []ToR{r.ToR1, r.ToR2}
+}
+
+// Nodes returns all the nodes in the rack; boot servers, cs and ss
+func (r Rack) Nodes() []Node {
+	var nodes []Node
+	for _, boot := range r.BootNodes {
+		nodes = append(nodes, boot.Node)
+	}
+	nodes = append(nodes, r.CSList...)
+	return append(nodes, r.SSList...)
 }
 
 // Node is a template args for a node
@@ -55,19 +91,75 @@ type Node struct {
 	Name         string
 	Fullname     string // some func compose full name by itself...
 	Serial       string
+	NICs         int
+	Bond         bool
 	Node0Address *net.IPNet
 	Node1Address *net.IPNet
-	Node2Address *net.IPNet
+	Node2Address *net.IPNet // nil unless the node has a routed NIC to ToR-2
 	ToR1Address  *net.IPNet
-	ToR2Address  *net.IPNet
+	ToR2Address  *net.IPNet // nil if the node is not connected to ToR-2
+	BMCAddress   *net.IPNet
+
+	// StorageAddress is nil unless the node is connected to the storage network.
+	// The storage networks of all racks in StorageNetwork are routed via StorageToRAddress.
+	StorageAddress    *net.IPNet
+	StorageToRAddress *net.IPNet
+	StorageNetwork    *net.IPNet
+}
+
+// PeerAddress returns the node's address to peer with the ToR switch.
+// torNumber is 1-origin.  It returns nil if the node is not connected to the ToR switch.
+func (n Node) PeerAddress(torNumber int) *net.IPNet {
+	switch {
+	case torNumber == 1:
+		return n.Node1Address
+	case n.Bond:
+		return n.Node1Address
+	default:
+		return n.Node2Address
+	}
 }
 
 // ToR is a template args for a ToR switch
 type ToR struct {
 	Name           string
 	SpineAddresses []*net.IPNet
+	SpineLinks     []SpineLink
 	NodeAddress    *net.IPNet
 	NodeInterface  string
+
+	// BondAddress and BondInterface are set for ToR-2 when bonded nodes
+	// share node1 network with ToR-1.
+	BondAddress   *net.IPNet
+	BondInterface string
+}
+
+// StorageToR is a template args for a storage ToR switch.
+// Storage ToR switches are connected to each other by the storage backbone network.
+type StorageToR struct {
+	Name            string
+	NodeNetwork     *net.IPNet
+	NodeAddress     *net.IPNet
+	NodeInterface   string
+	BackboneAddress *net.IPNet
+
+	storageNetwork *net.IPNet // storage network of all racks
+}
+
+// SpineLink is a template args for a link between a ToR switch and a spine
+type SpineLink struct {
+	Connected bool
+	Down      bool
+}
+
+// Connected returns true if the ToR switch is wired to the specified spine
+func (t ToR) Connected(spineIdx int) bool {
+	return t.SpineLinks[spineIdx].Connected
+}
+
+// Down returns true if the link to the specified spine is administratively down
+func (t ToR) Down(spineIdx int) bool {
+	return t.SpineLinks[spineIdx].Down
 }
 
 // BootNodeEntity is a template args for a boot node
@@ -77,6 +169,38 @@ type BootNodeEntity struct {
 	BastionAddress *net.IPNet
 }
 
+// MergePaths returns the merge paths option of kernel protocol
+func (p RoutingPolicy) MergePaths() string {
+	switch {
+	case p.ECMPLimit == 0:
+		return "merge paths;"
+	case p.ECMPLimit == 1:
+		return ""
+	default:
+		return fmt.Sprintf("merge paths on limit %d;", p.ECMPLimit)
+	}
+}
+
+// BGPOptions returns the options of BGP protocols
+func (p RoutingPolicy) BGPOptions() []string {
+	var options []string
+	if p.HoldTime > 0 {
+		options = append(options, fmt.Sprintf("hold time %d;", p.HoldTime))
+	}
+	if p.KeepaliveTime > 0 {
+		options = append(options, fmt.Sprintf("keepalive time %d;", p.KeepaliveTime))
+	}
+	if p.GracefulRestart {
+		options = append(options, "graceful restart on;")
+	}
+	return options
+}
+
+// SameBFD returns true if p and q have the same BFD options
+func (p RoutingPolicy) SameBFD(q RoutingPolicy) bool {
+	return p.BFDInterval == q.BFDInterval && p.BFDMultiplier == q.BFDMultiplier
+}
+
 // Spine is a template args for Spine
 type Spine struct {
 	Name         string
@@ -102,6 +226,15 @@ type Endpoints struct {
 	Operation *net.IPNet
 }
 
+// ExternalPeer is a template args for an external BGP peer of the core router
+type ExternalPeer struct {
+	Name        string
+	ASN         int
+	Address     *net.IPNet
+	CoreAddress *net.IPNet
+	Prefixes    []*net.IPNet
+}
+
 // Core contains parameters to construct core router
 type Core struct {
 	InternetAddress  *net.IPNet
@@ -111,51 +244,64 @@ type Core struct {
 	ExternalAddress  *net.IPNet
 }
 
+// ExposedNetworks contains the networks exposed outside of the cluster
+type ExposedNetworks struct {
+	Bastion      *net.IPNet
+	LoadBalancer *net.IPNet
+	Ingress      *net.IPNet
+	Global       *net.IPNet
+}
+
 // TemplateArgs is args for cluster.yml
 type TemplateArgs struct {
 	Network struct {
-		Exposed struct {
-			Bastion      *net.IPNet
-			LoadBalancer *net.IPNet
-			Ingress      *net.IPNet
-			Global       *net.IPNet
-		}
+		Exposed     ExposedNetworks
 		BMC         *net.IPNet
+		Storage     *net.IPNet
+		Backbone    *net.IPNet
 		Endpoints   Endpoints
 		ASNExternal int
 		ASNSpine    int
 		ASNCore     int
-	}
-	ClusterID string
-	Racks     []Rack
-	Spines    []Spine
-	Core      Core
-	CS        VMResource
-	SS        VMResource
-	Boot      VMResource
-	Images    []*imageSpec
-}
-
-// BIRDRackTemplateArgs is args to generate bird config for each rack
-type BIRDRackTemplateArgs struct {
-	Args    TemplateArgs
-	RackIdx int
-}
 
-// BIRDSpineTemplateArgs is args to generate bird config for each spine
-type BIRDSpineTemplateArgs struct {
-	Args     TemplateArgs
-	SpineIdx int
+		ShortenNames   bool
+		RoutingPolicy  RoutingPolicyMenu
+		RouterBackends RouterBackendsMenu
+	}
+	ClusterID     string
+	Racks         []Rack
+	Spines        []Spine
+	Core          Core
+	ExternalPeers []ExternalPeer
+	OutputFiles   []*OutputFileMenu
+	DataFolders   []*placemat.DataFolderSpec // additional DataFolders, e.g. registered by plugins
+	CS            VMResource
+	SS            VMResource
+	Boot          VMResource
+	Images        []*imageSpec
+
+	bgpPasswords map[string]string
 }
 
 // VMResource is args to specify vm resource
 type VMResource struct {
-	CPU               int
-	Memory            string
-	Image             string
-	Data              []string
-	UEFI              bool
-	CloudInitTemplate string
+	CPU                int
+	Memory             string
+	Image              string
+	Data               []string
+	UEFI               bool
+	CloudInitTemplate  string
+	IgnitionTemplate   string
+	VendorDataTemplate string
+	CloudInit          *CloudInitMenu
+	NICs               int
+	Bond               bool
+	Storage            bool
+}
+
+// HasSeed returns true if nodes of the resource have cloud-init seeds
+func (r *VMResource) HasSeed() bool {
+	return r.CloudInitTemplate != "" || r.CloudInit != nil
 }
 
 // ToTemplateArgs is converter Menu to TemplateArgs
@@ -180,6 +326,11 @@ func ToTemplateArgs(menu *Menu) (*TemplateArgs, error) {
 			templateArgs.CS.Data = node.Data
 			templateArgs.CS.UEFI = node.UEFI
 			templateArgs.CS.CloudInitTemplate = node.CloudInitTemplate
+			templateArgs.CS.IgnitionTemplate = node.IgnitionTemplate
+			templateArgs.CS.VendorDataTemplate = node.VendorDataTemplate
+			templateArgs.CS.CloudInit = node.CloudInit
+			templateArgs.CS.NICs = node.NICs
+			templateArgs.CS.Bond = node.Bond
 		case SSNode:
 			templateArgs.SS.Memory = node.Memory
 			templateArgs.SS.CPU = node.CPU
@@ -187,6 +338,11 @@ func ToTemplateArgs(menu *Menu) (*TemplateArgs, error) {
 			templateArgs.SS.Data = node.Data
 			templateArgs.SS.UEFI = node.UEFI
 			templateArgs.SS.CloudInitTemplate = node.CloudInitTemplate
+			templateArgs.SS.IgnitionTemplate = node.IgnitionTemplate
+			templateArgs.SS.VendorDataTemplate = node.VendorDataTemplate
+			templateArgs.SS.CloudInit = node.CloudInit
+			templateArgs.SS.NICs = node.NICs
+			templateArgs.SS.Bond = node.Bond
 		case BootNode:
 			templateArgs.Boot.Memory = node.Memory
 			templateArgs.Boot.CPU = node.CPU
@@ -194,6 +350,11 @@ func ToTemplateArgs(menu *Menu) (*TemplateArgs, error) {
 			templateArgs.Boot.Data = node.Data
 			templateArgs.Boot.UEFI = node.UEFI
 			templateArgs.Boot.CloudInitTemplate = node.CloudInitTemplate
+			templateArgs.Boot.IgnitionTemplate = node.IgnitionTemplate
+			templateArgs.Boot.VendorDataTemplate = node.VendorDataTemplate
+			templateArgs.Boot.CloudInit = node.CloudInit
+			templateArgs.Boot.NICs = node.NICs
+			templateArgs.Boot.Bond = node.Bond
 		default:
 			return nil, errors.New("invalid node type")
 		}
@@ -209,10 +370,57 @@ func ToTemplateArgs(menu *Menu) (*TemplateArgs, error) {
 		}
 	}
 
+	var numCS, numSS, numBoot int
+	for _, rack := range menu.Inventory.Rack {
+		numCS += rack.CS
+		numSS += rack.SS
+		numBoot += rack.Boot
+	}
+	hasBond := false
+	for _, r := range []struct {
+		resource *VMResource
+		used     bool
+	}{
+		{&templateArgs.CS, numCS > 0},
+		{&templateArgs.SS, numSS > 0},
+		{&templateArgs.Boot, numBoot > 0},
+	} {
+		if r.resource.NICs == 0 {
+			r.resource.NICs = torPerRack
+		}
+		// node types without nodes do not need addresses nor ToR interfaces
+		if !r.used {
+			continue
+		}
+		if r.resource.Bond {
+			hasBond = true
+		} else if r.resource.NICs > 1 && menu.Network.NodeIPPerNode <= torPerRack {
+			return nil, fmt.Errorf("node-ip-per-node in IPAM config must be %d for nodes with routed NICs", torPerRack+1)
+		}
+	}
+
 	templateArgs.ClusterID = menu.Inventory.ClusterID
 
 	numRack := len(menu.Inventory.Rack)
 
+	if menu.Network.Storage != nil {
+		err := validateStorageNetwork(menu.Network, numRack)
+		if err != nil {
+			return nil, err
+		}
+		templateArgs.SS.Storage = true
+		templateArgs.CS.Storage = menu.Network.StorageCS
+	}
+
+	err := validateRouteFilter(menu.Network.RoutingPolicy.Filter, numRack)
+	if err != nil {
+		return nil, err
+	}
+	err = validateCommunities(menu.Network.RoutingPolicy.Communities, numRack)
+	if err != nil {
+		return nil, err
+	}
+
 	spineToRackBases := make([][]net.IP, menu.Inventory.Spine)
 	spineTorInt := netutil.IP4ToInt(menu.Network.SpineTor)
 	for spineIdx := 0; spineIdx < menu.Inventory.Spine; spineIdx++ {
@@ -223,6 +431,7 @@ func ToTemplateArgs(menu *Menu) (*TemplateArgs, error) {
 		}
 	}
 
+	bootIdx := 0
 	templateArgs.Racks = make([]Rack, numRack)
 	for rackIdx, rackMenu := range menu.Inventory.Rack {
 		rack := &templateArgs.Racks[rackIdx]
@@ -230,20 +439,33 @@ func ToTemplateArgs(menu *Menu) (*TemplateArgs, error) {
 		rack.Index = rackIdx
 		rack.ShortName = fmt.Sprintf("r%d", rackIdx)
 		rack.ASN = menu.Network.ASNBase + rackIdx
-		rack.node0Network = makeNodeNetwork(menu.Network.NodeBase, menu.Network.NodeRangeSize, menu.Network.NodeRangeMask, rackIdx*3+0)
-		rack.node1Network = makeNodeNetwork(menu.Network.NodeBase, menu.Network.NodeRangeSize, menu.Network.NodeRangeMask, rackIdx*3+1)
-		rack.node2Network = makeNodeNetwork(menu.Network.NodeBase, menu.Network.NodeRangeSize, menu.Network.NodeRangeMask, rackIdx*3+2)
+		ipPerNode := menu.Network.NodeIPPerNode
+		rack.node0Network = makeNodeNetwork(menu.Network.NodeBase, menu.Network.NodeRangeSize, menu.Network.NodeRangeMask, rackIdx*ipPerNode+0)
+		rack.node1Network = makeNodeNetwork(menu.Network.NodeBase, menu.Network.NodeRangeSize, menu.Network.NodeRangeMask, rackIdx*ipPerNode+1)
+		if ipPerNode > 2 {
+			rack.node2Network = makeNodeNetwork(menu.Network.NodeBase, menu.Network.NodeRangeSize, menu.Network.NodeRangeMask, rackIdx*ipPerNode+2)
+		}
 
-		constructToRAddresses(rack, rackIdx, menu, spineToRackBases)
-		buildBootNode(rack, menu)
+		constructToRAddresses(rack, rackIdx, menu, spineToRackBases, hasBond)
+		constructToRLinks(rack, rackMenu, menu)
 		rack.NodeNetworkPrefixSize = menu.Network.NodeRangeMask
+		setRackBMC(rack, menu)
+		if menu.Network.Storage != nil {
+			constructStorageToR(rack, menu)
+		}
 
+		for i := 0; i < rackMenu.Boot; i++ {
+			node := buildBootNode(bootIdx, offsetNodenetBoot+i, rack, menu, &templateArgs.Boot)
+			rack.BootNodes = append(rack.BootNodes, node)
+			bootIdx++
+		}
+		offsetServers := offsetNodenetBoot + rackMenu.Boot
 		for csIdx := 0; csIdx < rackMenu.CS; csIdx++ {
-			node := buildNode("cs", csIdx, offsetNodenetServers, rack)
+			node := buildNode("cs", csIdx, offsetServers, rack, &templateArgs.CS)
 			rack.CSList = append(rack.CSList, node)
 		}
 		for ssIdx := 0; ssIdx < rackMenu.SS; ssIdx++ {
-			node := buildNode("ss", ssIdx, offsetNodenetServers+rackMenu.CS, rack)
+			node := buildNode("ss", ssIdx, offsetServers+rackMenu.CS, rack, &templateArgs.SS)
 			rack.SSList = append(rack.SSList, node)
 		}
 	}
@@ -264,11 +486,47 @@ func ToTemplateArgs(menu *Menu) (*TemplateArgs, error) {
 	}
 
 	setCore(&templateArgs, menu)
+	err = validateBMCAddresses(&templateArgs, menu.Network.BMCRangeSize)
+	if err != nil {
+		return nil, err
+	}
+
+	peerNames := map[string]bool{}
+	for _, p := range menu.ExternalPeers {
+		if peerNames[p.Name] {
+			return nil, errors.New("duplicate ExternalPeer: " + p.Name)
+		}
+		peerNames[p.Name] = true
+		templateArgs.ExternalPeers = append(templateArgs.ExternalPeers, ExternalPeer{
+			Name:        p.Name,
+			ASN:         p.ASN,
+			Address:     addToIPNet(p.Link, offsetExternalPeerPeer),
+			CoreAddress: addToIPNet(p.Link, offsetExternalPeerCore),
+			Prefixes:    p.Prefixes,
+		})
+	}
+
+	outputNames := map[string]bool{}
+	for _, o := range menu.OutputFiles {
+		if outputNames[o.Name] {
+			return nil, errors.New("duplicate OutputFile: " + o.Name)
+		}
+		outputNames[o.Name] = true
+	}
+	templateArgs.OutputFiles = menu.OutputFiles
+
+	err = setBGPPasswords(&templateArgs, menu)
+	if err != nil {
+		return nil, err
+	}
+
 	return &templateArgs, nil
 }
 
 func setNetworkArgs(templateArgs *TemplateArgs, menu *Menu) {
 	templateArgs.Network.BMC = menu.Network.BMC
+	templateArgs.Network.Storage = menu.Network.Storage
+	templateArgs.Network.Backbone = menu.Network.StorageBackbone
 	templateArgs.Network.ASNCore = menu.Network.ASNBase + offsetASNCore
 	templateArgs.Network.ASNExternal = menu.Network.ASNBase + offsetASNExternal
 	templateArgs.Network.ASNSpine = menu.Network.ASNBase + offsetASNSpine
@@ -279,35 +537,125 @@ func setNetworkArgs(templateArgs *TemplateArgs, menu *Menu) {
 	templateArgs.Network.Endpoints.Host = addToIPNet(menu.Network.Internet, offsetInternetHost)
 	templateArgs.Network.Endpoints.External = addToIPNet(menu.Network.CoreExternal, offsetExternalExternal)
 	templateArgs.Network.Endpoints.Operation = addToIPNet(menu.Network.CoreOperation, offsetOperationOperation)
+	templateArgs.Network.ShortenNames = menu.Network.ShortenNames
+	templateArgs.Network.RoutingPolicy = menu.Network.RoutingPolicy
+	templateArgs.Network.RouterBackends = menu.Network.RouterBackends
 }
 
-func buildNode(basename string, idx int, offsetStart int, rack *Rack) Node {
+func buildNode(basename string, idx int, offsetStart int, rack *Rack, resource *VMResource) Node {
 	node := Node{}
 	node.Name = fmt.Sprintf("%v%d", basename, idx+1)
 	node.Fullname = fmt.Sprintf("%s-%s", rack.Name, node.Name)
 	node.Serial = fmt.Sprintf("%x", sha1.Sum([]byte(node.Fullname)))
-	offset := offsetStart + idx
+	setNodeAddresses(&node, offsetStart+idx, rack, resource)
+	return node
+}
 
-	node.Node0Address = addToIP(rack.node0Network.IP, offset, 32)
-	node.Node1Address = addToIPNet(rack.node1Network, offset)
-	node.Node2Address = addToIPNet(rack.node2Network, offset)
-	node.ToR1Address = rack.BootNode.ToR1Address
-	node.ToR2Address = rack.BootNode.ToR2Address
+// buildBootNode builds a boot server.  Boot servers are numbered
+// sequentially across racks, and idx is the number of the boot server.
+func buildBootNode(idx int, offset int, rack *Rack, menu *Menu, resource *VMResource) BootNodeEntity {
+	node := BootNodeEntity{}
+	node.Name = "boot"
+	node.Fullname = fmt.Sprintf("%s-%d", node.Name, idx)
+	node.Serial = fmt.Sprintf("%x", sha1.Sum([]byte(node.Fullname)))
+	setNodeAddresses(&node.Node, offset, rack, resource)
+	node.BastionAddress = addToIP(menu.Network.Bastion.IP, idx, 32)
 	return node
 }
 
-func buildBootNode(rack *Rack, menu *Menu) {
-	rack.BootNode.Name = "boot"
-	rack.BootNode.Fullname = fmt.Sprintf("%s-%d", rack.BootNode.Name, rack.Index)
-	rack.BootNode.Serial = fmt.Sprintf("%x", sha1.Sum([]byte(rack.BootNode.Fullname)))
+// setRackBMC sets BMC network of the rack in the same way as sabakan
+// assigns BMC addresses.
+func setRackBMC(rack *Rack, menu *Menu) {
+	rangeSize := uint(menu.Network.BMCRangeSize)
+	mask := net.CIDRMask(menu.Network.BMCRangeMask, 32)
+	rack.bmcBase = netutil.IntToIP4(netutil.IP4ToInt(menu.Network.BMCBase) + uint32(rack.Index)<<rangeSize)
+	rack.BMCNetwork = &net.IPNet{IP: rack.bmcBase.Mask(mask), Mask: mask}
+	rack.bmcPrefixSize, _ = menu.Network.BMC.Mask.Size()
+
+	if menu.Network.BMCRangeMask > rack.bmcPrefixSize {
+		rack.BMCGatewayAddress = addToIPNet(rack.BMCNetwork, offsetBMCRackCore)
+		rack.bmcPrefixSize = menu.Network.BMCRangeMask
+	}
+}
 
-	rack.BootNode.Node0Address = addToIP(rack.node0Network.IP, offsetNodenetBoot, 32)
-	rack.BootNode.Node1Address = addToIPNet(rack.node1Network, offsetNodenetBoot)
-	rack.BootNode.Node2Address = addToIPNet(rack.node2Network, offsetNodenetBoot)
-	rack.BootNode.BastionAddress = addToIP(menu.Network.Bastion.IP, rack.Index, 32)
+// validateBMCAddresses checks that BMC addresses of all nodes are in the BMC
+// range of their racks and bmc-ipv4-pool, and do not conflict with each other.
+func validateBMCAddresses(ta *TemplateArgs, rangeSize int) error {
+	reserved := map[string]string{
+		addToIP(ta.Network.BMC.IP, offsetBMCHost, 32).IP.String(): "the host",
+		ta.Core.BMCAddress.IP.String():                            "the core",
+	}
+	for _, rack := range ta.Racks {
+		if rack.BMCGatewayAddress != nil {
+			reserved[rack.BMCGatewayAddress.IP.String()] = "the core"
+		}
+		for _, node := range rack.Nodes() {
+			offset := netutil.IP4ToInt(node.BMCAddress.IP) - netutil.IP4ToInt(rack.bmcBase)
+			if offset >= 1<<uint(rangeSize) || !rack.BMCNetwork.Contains(node.BMCAddress.IP) {
+				return fmt.Errorf("BMC address of %s is out of the BMC range of %s: %v", node.Fullname, rack.Name, node.BMCAddress.IP)
+			}
+			if !ta.Network.BMC.Contains(node.BMCAddress.IP) {
+				return fmt.Errorf("BMC address of %s is out of bmc-ipv4-pool %v: %v", node.Fullname, ta.Network.BMC, node.BMCAddress.IP)
+			}
+			if owner, ok := reserved[node.BMCAddress.IP.String()]; ok {
+				return fmt.Errorf("BMC address of %s conflicts with %s: %v", node.Fullname, owner, node.BMCAddress.IP)
+			}
+			reserved[node.BMCAddress.IP.String()] = node.Fullname
+		}
+	}
+	return nil
+}
+
+func validateStorageNetwork(network *NetworkMenu, numRack int) error {
+	ones, bits := network.Storage.Mask.Size()
+	if numRack<<uint(network.StorageRangeSize) > 1<<uint(bits-ones) {
+		return errors.New("storage network is too small for racks")
+	}
+	ones, bits = network.StorageBackbone.Mask.Size()
+	if numRack+offsetBackboneStorage >= 1<<uint(bits-ones) {
+		return errors.New("storage backbone network is too small for racks")
+	}
+	return nil
+}
+
+func constructStorageToR(rack *Rack, menu *Menu) {
+	rangeSize := uint(menu.Network.StorageRangeSize)
+	base := netutil.IP4ToInt(menu.Network.Storage.IP) + uint32(rack.Index)<<rangeSize
+	network := &net.IPNet{IP: netutil.IntToIP4(base), Mask: net.CIDRMask(32-int(rangeSize), 32)}
+
+	rack.StorageToR = &StorageToR{
+		Name:            fmt.Sprintf("%s-stor", rack.Name),
+		NodeNetwork:     network,
+		NodeAddress:     addToIPNet(network, offsetStorageToR),
+		NodeInterface:   "eth1",
+		BackboneAddress: addToIPNet(menu.Network.StorageBackbone, offsetBackboneStorage+rack.Index),
+		storageNetwork:  menu.Network.Storage,
+	}
+}
 
-	rack.BootNode.ToR1Address = addToIPNet(rack.node1Network, offsetNodenetToR)
-	rack.BootNode.ToR2Address = addToIPNet(rack.node2Network, offsetNodenetToR)
+func setNodeAddresses(node *Node, offset int, rack *Rack, resource *VMResource) {
+	node.NICs = resource.NICs
+	node.Bond = resource.Bond
+	node.BMCAddress = addToIP(rack.bmcBase, offset, rack.bmcPrefixSize)
+	if resource.Storage && rack.StorageToR != nil {
+		node.StorageAddress = addToIPNet(rack.StorageToR.NodeNetwork, offset)
+		node.StorageToRAddress = rack.StorageToR.NodeAddress
+		node.StorageNetwork = rack.StorageToR.storageNetwork
+	}
+
+	node.Node0Address = addToIP(rack.node0Network.IP, offset, 32)
+	node.Node1Address = addToIPNet(rack.node1Network, offset)
+	node.ToR1Address = rack.ToR1.NodeAddress
+	switch {
+	case node.Bond && rack.ToR2.BondAddress != nil:
+		node.ToR2Address = rack.ToR2.BondAddress
+	case node.Bond:
+		// ToR-2 has no node2 network, and is connected only to node1 network
+		node.ToR2Address = rack.ToR2.NodeAddress
+	case node.NICs > 1:
+		node.Node2Address = addToIPNet(rack.node2Network, offset)
+		node.ToR2Address = rack.ToR2.NodeAddress
+	}
 }
 
 func setCore(ta *TemplateArgs, menu *Menu) {
@@ -320,20 +668,48 @@ func setCore(ta *TemplateArgs, menu *Menu) {
 	ta.Core.ExternalAddress = addToIPNet(menu.Network.CoreExternal, offsetExternalCore)
 }
 
-func constructToRAddresses(rack *Rack, rackIdx int, menu *Menu, bases [][]net.IP) {
+func constructToRAddresses(rack *Rack, rackIdx int, menu *Menu, bases [][]net.IP, hasBond bool) {
+	rack.ToR1.Name = fmt.Sprintf("%s-tor1", rack.Name)
+	rack.ToR2.Name = fmt.Sprintf("%s-tor2", rack.Name)
+
 	rack.ToR1.SpineAddresses = make([]*net.IPNet, menu.Inventory.Spine)
 	for spineIdx := 0; spineIdx < menu.Inventory.Spine; spineIdx++ {
 		rack.ToR1.SpineAddresses[spineIdx] = addToIP(bases[spineIdx][rackIdx], 1, 31)
 	}
 	rack.ToR1.NodeAddress = addToIPNet(rack.node1Network, offsetNodenetToR)
-	rack.ToR1.NodeInterface = fmt.Sprintf("eth%d", menu.Inventory.Spine)
 
 	rack.ToR2.SpineAddresses = make([]*net.IPNet, menu.Inventory.Spine)
 	for spineIdx := 0; spineIdx < menu.Inventory.Spine; spineIdx++ {
 		rack.ToR2.SpineAddresses[spineIdx] = addToIP(bases[spineIdx][rackIdx], 3, 31)
 	}
-	rack.ToR2.NodeAddress = addToIPNet(rack.node2Network, offsetNodenetToR)
-	rack.ToR2.NodeInterface = fmt.Sprintf("eth%d", menu.Inventory.Spine)
+	switch {
+	case rack.node2Network == nil:
+		rack.ToR2.NodeAddress = addToIPNet(rack.node1Network, offsetNodenetToR2)
+	case hasBond:
+		rack.ToR2.NodeAddress = addToIPNet(rack.node2Network, offsetNodenetToR)
+		rack.ToR2.BondAddress = addToIPNet(rack.node1Network, offsetNodenetToR2)
+	default:
+		rack.ToR2.NodeAddress = addToIPNet(rack.node2Network, offsetNodenetToR)
+	}
+}
+
+func constructToRLinks(rack *Rack, rackMenu RackMenu, menu *Menu) {
+	for i, tor := range []*ToR{&rack.ToR1, &rack.ToR2} {
+		tor.SpineLinks = make([]SpineLink, menu.Inventory.Spine)
+		numLinks := 0
+		for spineIdx := range tor.SpineLinks {
+			tor.SpineLinks[spineIdx].Connected = rackMenu.Connected(spineIdx, i+1)
+			tor.SpineLinks[spineIdx].Down = rackMenu.Down(spineIdx, i+1)
+			if tor.SpineLinks[spineIdx].Connected {
+				numLinks++
+			}
+		}
+		// interfaces to spines come first, then the interfaces to nodes
+		tor.NodeInterface = fmt.Sprintf("eth%d", numLinks)
+		if tor.BondAddress != nil {
+			tor.BondInterface = fmt.Sprintf("eth%d", numLinks+1)
+		}
+	}
 }
 
 func addToIPNet(netAddr *net.IPNet, offset int) *net.IPNet {
@@ -8,6 +8,8 @@ import (
 	"io"
 	"net"
 	"os"
+	"path"
+	"strconv"
 
 	"github.com/cybozu-go/netutil"
 	"github.com/cybozu-go/placemat"
@@ -29,22 +31,86 @@ type networkConfig struct {
 		CoreSpine     string `yaml:"core-spine"`
 		CoreExternal  string `yaml:"core-external"`
 		CoreOperation string `yaml:"core-operation"`
+		ShortenNames  bool   `yaml:"shorten-names"`
 		Exposed       struct {
 			Bastion      string `yaml:"bastion"`
 			LoadBalancer string `yaml:"loadbalancer"`
 			Ingress      string `yaml:"ingress"`
 			Global       string `yaml:"global"`
 		} `yaml:"exposed"`
+		Storage *struct {
+			Network   string `yaml:"network"`
+			RangeSize int    `yaml:"range-size"`
+			Backbone  string `yaml:"backbone"`
+			CS        bool   `yaml:"cs"`
+		} `yaml:"storage"`
+		BGPAuth *struct {
+			Seed        string `yaml:"seed"`
+			SecretsFile string `yaml:"secrets-file"`
+			Nodes       bool   `yaml:"nodes"`
+		} `yaml:"bgp-auth"`
+		RoutingPolicy struct {
+			Default *routingPolicyConfig `yaml:"default"`
+			Core    *routingPolicyConfig `yaml:"core"`
+			Spine   *routingPolicyConfig `yaml:"spine"`
+			ToR     *routingPolicyConfig `yaml:"tor"`
+			Node    *routingPolicyConfig `yaml:"node"`
+			Filter  *struct {
+				ExposedRacks []int    `yaml:"exposed-racks"`
+				NodePrefixes []string `yaml:"node-prefixes"`
+			} `yaml:"filter"`
+			Communities *struct {
+				ASN       *int `yaml:"asn"`
+				RackBase  *int `yaml:"rack-base"`
+				NodeTypes struct {
+					Boot *int `yaml:"boot"`
+					CS   *int `yaml:"cs"`
+					SS   *int `yaml:"ss"`
+				} `yaml:"node-types"`
+				Exposed struct {
+					Bastion      *int `yaml:"bastion"`
+					LoadBalancer *int `yaml:"loadbalancer"`
+					Ingress      *int `yaml:"ingress"`
+					Global       *int `yaml:"global"`
+				} `yaml:"exposed"`
+			} `yaml:"communities"`
+		} `yaml:"routing-policy"`
+		RouterBackends struct {
+			Core  string `yaml:"core"`
+			Spine string `yaml:"spine"`
+			ToR   string `yaml:"tor"`
+		} `yaml:"router-backends"`
 	} `yaml:"spec"`
 }
 
+type routingPolicyConfig struct {
+	BFD struct {
+		Interval   *int `yaml:"interval"`
+		Multiplier *int `yaml:"multiplier"`
+	} `yaml:"bfd"`
+	BGP struct {
+		HoldTime        *int  `yaml:"hold-time"`
+		KeepaliveTime   *int  `yaml:"keepalive-time"`
+		GracefulRestart *bool `yaml:"graceful-restart"`
+		ECMPLimit       *int  `yaml:"ecmp-limit"`
+		AddPaths        *bool `yaml:"add-paths"`
+	} `yaml:"bgp"`
+}
+
 type inventoryConfig struct {
 	Spec struct {
 		ClusterID string `yaml:"cluster-id"`
 		Spine     int    `yaml:"spine"`
 		Rack      []struct {
-			CS int `yaml:"cs"`
-			SS int `yaml:"ss"`
+			CS        int           `yaml:"cs"`
+			SS        int           `yaml:"ss"`
+			Boot      *int          `yaml:"boot"`
+			Spines    []int         `yaml:"spines"`
+			ToRSpines map[int][]int `yaml:"tor-spines"`
+			DownLinks []struct {
+				Spine int `yaml:"spine"`
+				ToR   int `yaml:"tor"`
+			} `yaml:"down-links"`
 		} `yaml:"rack"`
 	} `yaml:"spec"`
 }
@@ -54,12 +120,64 @@ type imageSpec = placemat.ImageSpec
 type nodeConfig struct {
 	Type string `yaml:"type"`
 	Spec struct {
-		CPU               int      `yaml:"cpu"`
-		Memory            string   `yaml:"memory"`
-		Image             string   `yaml:"image"`
-		Data              []string `yaml:"data"`
-		UEFI              bool     `yaml:"uefi"`
-		CloudInitTemplate string   `yaml:"cloud-init-template"`
+		CPU                int              `yaml:"cpu"`
+		Memory             string           `yaml:"memory"`
+		Image              string           `yaml:"image"`
+		Data               []string         `yaml:"data"`
+		UEFI               bool             `yaml:"uefi"`
+		CloudInitTemplate  string           `yaml:"cloud-init-template"`
+		IgnitionTemplate   string           `yaml:"ignition-template"`
+		VendorDataTemplate string           `yaml:"vendor-data-template"`
+		CloudInit          *cloudInitConfig `yaml:"cloud-init"`
+		NICs               int              `yaml:"nics"`
+		Bond               bool             `yaml:"bond"`
+	} `yaml:"spec"`
+}
+
+type cloudInitConfig struct {
+	Users []struct {
+		Name              string   `yaml:"name"`
+		Groups            []string `yaml:"groups"`
+		Shell             string   `yaml:"shell"`
+		Sudo              string   `yaml:"sudo"`
+		LockPasswd        *bool    `yaml:"lock-passwd"`
+		Passwd            string   `yaml:"passwd"`
+		SSHAuthorizedKeys []string `yaml:"ssh-authorized-keys"`
+	} `yaml:"users"`
+	SSHAuthorizedKeys []string `yaml:"ssh-authorized-keys"`
+	Packages          []string `yaml:"packages"`
+	WriteFiles        []struct {
+		Path        string `yaml:"path"`
+		Content     string `yaml:"content"`
+		Permissions string `yaml:"permissions"`
+		Owner       string `yaml:"owner"`
+	} `yaml:"write-files"`
+	RunCmd []string `yaml:"runcmd"`
+}
+
+type externalPeerConfig struct {
+	Name string `yaml:"name"`
+	Spec struct {
+		ASN      int      `yaml:"asn"`
+		Link     string   `yaml:"link"`
+		Prefixes []string `yaml:"prefixes"`
+	} `yaml:"spec"`
+}
+
+type outputFileConfig struct {
+	Name string `yaml:"name"`
+	Spec struct {
+		Template string `yaml:"template"`
+		Output   string `yaml:"output"`
+		Scope    string `yaml:"scope"`
+		Attach   bool   `yaml:"attach"`
+	} `yaml:"spec"`
+}
+
+type generatorConfig struct {
+	Spec struct {
+		TemplateDir string   `yaml:"template-dir"`
+		Plugins     []string `yaml:"plugins"`
 	} `yaml:"spec"`
 }
 
@@ -80,6 +198,118 @@ func parseNetworkCIDR(s string) (net.IP, *net.IPNet, error) {
 	return ip, network, nil
 }
 
+// applyRoutingPolicy overrides p with the values specified in c
+func applyRoutingPolicy(p *RoutingPolicy, c *routingPolicyConfig) {
+	if c == nil {
+		return
+	}
+	if c.BFD.Interval != nil {
+		p.BFDInterval = *c.BFD.Interval
+	}
+	if c.BFD.Multiplier != nil {
+		p.BFDMultiplier = *c.BFD.Multiplier
+	}
+	if c.BGP.HoldTime != nil {
+		p.HoldTime = *c.BGP.HoldTime
+	}
+	if c.BGP.KeepaliveTime != nil {
+		p.KeepaliveTime = *c.BGP.KeepaliveTime
+	}
+	if c.BGP.GracefulRestart != nil {
+		p.GracefulRestart = *c.BGP.GracefulRestart
+	}
+	if c.BGP.ECMPLimit != nil {
+		p.ECMPLimit = *c.BGP.ECMPLimit
+	}
+	if c.BGP.AddPaths != nil {
+		p.AddPaths = *c.BGP.AddPaths
+	}
+}
+
+func validateRoutingPolicy(p RoutingPolicy) error {
+	if p.BFDInterval <= 0 {
+		return errors.New("bfd interval must be more than 0")
+	}
+	if p.BFDMultiplier < 0 || p.BFDMultiplier > 255 {
+		return errors.New("bfd multiplier must be between 1 and 255")
+	}
+	if p.HoldTime < 0 || (p.HoldTime > 0 && p.HoldTime < 3) {
+		return errors.New("hold-time must be 3 or more")
+	}
+	if p.KeepaliveTime < 0 {
+		return errors.New("keepalive-time must not be negative")
+	}
+	if p.HoldTime > 0 && p.KeepaliveTime >= p.HoldTime {
+		return errors.New("keepalive-time must be less than hold-time")
+	}
+	if p.ECMPLimit < 0 {
+		return errors.New("ecmp-limit must not be negative")
+	}
+	return nil
+}
+
+func unmarshalRoutingPolicy(n *networkConfig) (RoutingPolicyMenu, error) {
+	var menu RoutingPolicyMenu
+
+	base := defaultRoutingPolicy
+	applyRoutingPolicy(&base, n.Spec.RoutingPolicy.Default)
+
+	tiers := []struct {
+		name   string
+		policy *RoutingPolicy
+		config *routingPolicyConfig
+	}{
+		{"core", &menu.Core, n.Spec.RoutingPolicy.Core},
+		{"spine", &menu.Spine, n.Spec.RoutingPolicy.Spine},
+		{"tor", &menu.ToR, n.Spec.RoutingPolicy.ToR},
+		{"node", &menu.Node, n.Spec.RoutingPolicy.Node},
+	}
+	for _, t := range tiers {
+		*t.policy = base
+		applyRoutingPolicy(t.policy, t.config)
+		err := validateRoutingPolicy(*t.policy)
+		if err != nil {
+			return menu, fmt.Errorf("invalid routing-policy for %s: %v", t.name, err)
+		}
+	}
+
+	if f := n.Spec.RoutingPolicy.Filter; f != nil {
+		menu.Filter = &RouteFilterMenu{ExposedRacks: f.ExposedRacks}
+		for _, prefix := range f.NodePrefixes {
+			_, p, err := parseNetworkCIDR(prefix)
+			if err != nil {
+				return menu, err
+			}
+			menu.Filter.NodePrefixes = append(menu.Filter.NodePrefixes, p)
+		}
+	}
+
+	if c := n.Spec.RoutingPolicy.Communities; c != nil {
+		communities := defaultCommunities
+		communities.ASN = n.Spec.ASNBase
+		for _, v := range []struct {
+			dst *int
+			src *int
+		}{
+			{&communities.ASN, c.ASN},
+			{&communities.RackBase, c.RackBase},
+			{&communities.Boot, c.NodeTypes.Boot},
+			{&communities.CS, c.NodeTypes.CS},
+			{&communities.SS, c.NodeTypes.SS},
+			{&communities.Bastion, c.Exposed.Bastion},
+			{&communities.LoadBalancer, c.Exposed.LoadBalancer},
+			{&communities.Ingress, c.Exposed.Ingress},
+			{&communities.Global, c.Exposed.Global},
+		} {
+			if v.src != nil {
+				*v.dst = *v.src
+			}
+		}
+		menu.Communities = &communities
+	}
+	return menu, nil
+}
+
 func unmarshalNetwork(data []byte) (*NetworkMenu, error) {
 	var n networkConfig
 	err := yaml.Unmarshal(data, &n)
@@ -100,9 +330,10 @@ func unmarshalNetwork(data []byte) (*NetworkMenu, error) {
 	if err != nil {
 		return nil, err
 	}
-	if ic.NodeIPPerNode != torPerRack+1 {
-		return nil, fmt.Errorf("node-ip-per-node in IPAM config must be %d", torPerRack+1)
+	if ic.NodeIPPerNode != 2 && ic.NodeIPPerNode != torPerRack+1 {
+		return nil, fmt.Errorf("node-ip-per-node in IPAM config must be 2 or %d", torPerRack+1)
 	}
+	network.NodeIPPerNode = int(ic.NodeIPPerNode)
 	if ic.NodeIndexOffset != offsetNodenetBoot {
 		return nil, fmt.Errorf("node-index-offset in IPAM config must be %d", offsetNodenetBoot)
 	}
@@ -117,10 +348,21 @@ func unmarshalNetwork(data []byte) (*NetworkMenu, error) {
 	network.NodeBase = netutil.IntToIP4(netutil.IP4ToInt(nodePool) + nodeOffset)
 	network.NodeRangeSize = int(ic.NodeRangeSize)
 	network.NodeRangeMask = int(ic.NodeRangeMask)
-	_, network.BMC, err = parseNetworkCIDR(ic.BMCIPv4Pool)
+	bmcPool, bmcNet, err := parseNetworkCIDR(ic.BMCIPv4Pool)
 	if err != nil {
 		return nil, err
 	}
+	network.BMC = bmcNet
+	bmcOffset := uint32(0)
+	if len(ic.BMCIPv4Offset) > 0 {
+		bmcOffset = netutil.IP4ToInt(net.ParseIP(ic.BMCIPv4Offset))
+	}
+	network.BMCBase = netutil.IntToIP4(netutil.IP4ToInt(bmcPool) + bmcOffset)
+	network.BMCRangeSize = int(ic.BMCRangeSize)
+	network.BMCRangeMask = int(ic.BMCRangeMask)
+	if ones, _ := bmcNet.Mask.Size(); network.BMCRangeMask < ones {
+		return nil, errors.New("bmc-ipv4-range-mask in IPAM config must not be shorter than bmc-ipv4-pool")
+	}
 
 	network.ASNBase = n.Spec.ASNBase
 
@@ -146,6 +388,34 @@ func unmarshalNetwork(data []byte) (*NetworkMenu, error) {
 		return nil, errors.New("Invalid IP address: " + n.Spec.SpineTor)
 	}
 
+	network.ShortenNames = n.Spec.ShortenNames
+
+	network.RoutingPolicy, err = unmarshalRoutingPolicy(&n)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range []struct {
+		dst  *string
+		name string
+	}{
+		{&network.RouterBackends.Core, n.Spec.RouterBackends.Core},
+		{&network.RouterBackends.Spine, n.Spec.RouterBackends.Spine},
+		{&network.RouterBackends.ToR, n.Spec.RouterBackends.ToR},
+	} {
+		if b.name == "" {
+			b.name = backendBIRD
+		}
+		if _, ok := routerBackends[b.name]; !ok {
+			return nil, errors.New("unknown router backend: " + b.name)
+		}
+		*b.dst = b.name
+	}
+	err = validateRouterBackends(&network)
+	if err != nil {
+		return nil, err
+	}
+
 	_, network.Bastion, err = parseNetworkCIDR(n.Spec.Exposed.Bastion)
 	if err != nil {
 		return nil, err
@@ -163,6 +433,39 @@ func unmarshalNetwork(data []byte) (*NetworkMenu, error) {
 		return nil, err
 	}
 
+	if n.Spec.Storage != nil {
+		_, network.Storage, err = parseNetworkCIDR(n.Spec.Storage.Network)
+		if err != nil {
+			return nil, err
+		}
+		ones, bits := network.Storage.Mask.Size()
+		if n.Spec.Storage.RangeSize < 2 || n.Spec.Storage.RangeSize > bits-ones {
+			return nil, errors.New("range-size in storage is out of the storage network")
+		}
+		network.StorageRangeSize = n.Spec.Storage.RangeSize
+		_, network.StorageBackbone, err = parseNetworkCIDR(n.Spec.Storage.Backbone)
+		if err != nil {
+			return nil, err
+		}
+		network.StorageCS = n.Spec.Storage.CS
+	}
+
+	if n.Spec.BGPAuth != nil {
+		if n.Spec.BGPAuth.Seed == "" && n.Spec.BGPAuth.SecretsFile == "" {
+			return nil, errors.New("bgp-auth requires seed or secrets-file")
+		}
+		network.BGPAuth = &BGPAuthMenu{
+			Seed:  n.Spec.BGPAuth.Seed,
+			Nodes: n.Spec.BGPAuth.Nodes,
+		}
+		if n.Spec.BGPAuth.SecretsFile != "" {
+			network.BGPAuth.Secrets, err = readBGPSecrets(n.Spec.BGPAuth.SecretsFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return &network, nil
 }
 
@@ -186,10 +489,51 @@ func unmarshalInventory(data []byte) (*InventoryMenu, error) {
 	inventory.Spine = i.Spec.Spine
 
 	inventory.Rack = []RackMenu{}
-	for _, r := range i.Spec.Rack {
+	for rackIdx, r := range i.Spec.Rack {
 		var rack RackMenu
 		rack.CS = r.CS
 		rack.SS = r.SS
+
+		rack.Boot = 1
+		if r.Boot != nil {
+			if *r.Boot < 0 {
+				return nil, fmt.Errorf("boot in rack %d must not be negative", rackIdx)
+			}
+			rack.Boot = *r.Boot
+		}
+
+		for _, spine := range r.Spines {
+			if spine < 1 || spine > inventory.Spine {
+				return nil, fmt.Errorf("spine %d in rack %d does not exist", spine, rackIdx)
+			}
+		}
+		rack.Spines = r.Spines
+
+		for tor, spines := range r.ToRSpines {
+			if tor < 1 || tor > torPerRack {
+				return nil, fmt.Errorf("tor %d in tor-spines of rack %d does not exist", tor, rackIdx)
+			}
+			for _, spine := range spines {
+				if spine < 1 || spine > inventory.Spine {
+					return nil, fmt.Errorf("spine %d in tor-spines of rack %d does not exist", spine, rackIdx)
+				}
+			}
+		}
+		rack.ToRSpines = r.ToRSpines
+
+		for _, l := range r.DownLinks {
+			if l.Spine < 1 || l.Spine > inventory.Spine {
+				return nil, fmt.Errorf("spine %d in down-links of rack %d does not exist", l.Spine, rackIdx)
+			}
+			if l.ToR < 1 || l.ToR > torPerRack {
+				return nil, fmt.Errorf("tor %d in down-links of rack %d does not exist", l.ToR, rackIdx)
+			}
+			if !rack.Connected(l.Spine-1, l.ToR) {
+				return nil, fmt.Errorf("spine %d in down-links of rack %d is not wired to tor %d", l.Spine, rackIdx, l.ToR)
+			}
+			rack.DownLinks = append(rack.DownLinks, LinkMenu{Spine: l.Spine, ToR: l.ToR})
+		}
+
 		inventory.Rack = append(inventory.Rack, rack)
 	}
 
@@ -231,10 +575,183 @@ func unmarshalNode(data []byte) (*NodeMenu, error) {
 	node.Data = n.Spec.Data
 	node.UEFI = n.Spec.UEFI
 	node.CloudInitTemplate = n.Spec.CloudInitTemplate
+	node.IgnitionTemplate = n.Spec.IgnitionTemplate
+	if node.CloudInitTemplate != "" && node.IgnitionTemplate != "" {
+		return nil, errors.New("cloud-init-template and ignition-template in Node are exclusive")
+	}
+	if n.Spec.CloudInit != nil {
+		if node.IgnitionTemplate != "" {
+			return nil, errors.New("cloud-init and ignition-template in Node are exclusive")
+		}
+		node.CloudInit, err = unmarshalCloudInit(n.Spec.CloudInit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	node.VendorDataTemplate = n.Spec.VendorDataTemplate
+	if node.VendorDataTemplate != "" && node.CloudInitTemplate == "" && node.CloudInit == nil {
+		return nil, errors.New("vendor-data-template in Node requires cloud-init-template or cloud-init")
+	}
+
+	if n.Spec.NICs < 0 {
+		return nil, errors.New("nics in Node must not be negative")
+	}
+	if !n.Spec.Bond && n.Spec.NICs > torPerRack {
+		return nil, fmt.Errorf("nics in Node must not be more than %d unless bond is enabled", torPerRack)
+	}
+	node.NICs = n.Spec.NICs
+	node.Bond = n.Spec.Bond
 
 	return &node, nil
 }
 
+func unmarshalCloudInit(c *cloudInitConfig) (*CloudInitMenu, error) {
+	ci := &CloudInitMenu{
+		SSHAuthorizedKeys: c.SSHAuthorizedKeys,
+		Packages:          c.Packages,
+		RunCmd:            c.RunCmd,
+	}
+
+	users := make(map[string]bool)
+	for _, u := range c.Users {
+		if u.Name == "" {
+			return nil, errors.New("name of user in cloud-init is empty")
+		}
+		if users[u.Name] {
+			return nil, errors.New("duplicate user in cloud-init: " + u.Name)
+		}
+		users[u.Name] = true
+		ci.Users = append(ci.Users, CloudInitUser{
+			Name:              u.Name,
+			Groups:            u.Groups,
+			Shell:             u.Shell,
+			Sudo:              u.Sudo,
+			LockPasswd:        u.LockPasswd,
+			Passwd:            u.Passwd,
+			SSHAuthorizedKeys: u.SSHAuthorizedKeys,
+		})
+	}
+
+	files := make(map[string]bool)
+	for _, f := range c.WriteFiles {
+		if !path.IsAbs(f.Path) {
+			return nil, errors.New("path of write-files in cloud-init must be absolute: " + f.Path)
+		}
+		if files[f.Path] {
+			return nil, errors.New("duplicate file in cloud-init: " + f.Path)
+		}
+		files[f.Path] = true
+		if f.Permissions != "" {
+			mode, err := strconv.ParseUint(f.Permissions, 8, 32)
+			if err != nil || mode > 07777 {
+				return nil, fmt.Errorf("invalid permissions of %s in cloud-init: %s", f.Path, f.Permissions)
+			}
+		}
+		ci.WriteFiles = append(ci.WriteFiles, CloudInitFile{
+			Path:        f.Path,
+			Content:     f.Content,
+			Permissions: f.Permissions,
+			Owner:       f.Owner,
+		})
+	}
+
+	return ci, nil
+}
+
+func unmarshalExternalPeer(data []byte) (*ExternalPeerMenu, error) {
+	var p externalPeerConfig
+	err := yaml.Unmarshal(data, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	var peer ExternalPeerMenu
+
+	if p.Name == "" {
+		return nil, errors.New("name of ExternalPeer is empty")
+	}
+	peer.Name = p.Name
+
+	if !(p.Spec.ASN > 0) {
+		return nil, errors.New("asn in ExternalPeer must be more than 0")
+	}
+	peer.ASN = p.Spec.ASN
+
+	_, peer.Link, err = parseNetworkCIDR(p.Spec.Link)
+	if err != nil {
+		return nil, err
+	}
+	if ones, _ := peer.Link.Mask.Size(); ones > 30 {
+		return nil, errors.New("link in ExternalPeer must be /30 or larger: " + p.Spec.Link)
+	}
+
+	for _, prefix := range p.Spec.Prefixes {
+		_, n, err := parseNetworkCIDR(prefix)
+		if err != nil {
+			return nil, err
+		}
+		peer.Prefixes = append(peer.Prefixes, n)
+	}
+
+	return &peer, nil
+}
+
+func unmarshalOutputFile(data []byte) (*OutputFileMenu, error) {
+	var o outputFileConfig
+	err := yaml.Unmarshal(data, &o)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Name == "" {
+		return nil, errors.New("name of OutputFile is empty")
+	}
+	if o.Spec.Template == "" {
+		return nil, errors.New("template in OutputFile is empty: " + o.Name)
+	}
+	if o.Spec.Output == "" {
+		return nil, errors.New("output in OutputFile is empty: " + o.Name)
+	}
+
+	scope := o.Spec.Scope
+	if scope == "" {
+		scope = scopeCluster
+	}
+	switch scope {
+	case scopeCluster, scopeRack:
+		if o.Spec.Attach {
+			return nil, fmt.Errorf("OutputFile %s of scope %s cannot be attached", o.Name, scope)
+		}
+	case scopeNode, scopeSpine, scopeToR:
+	default:
+		return nil, errors.New("unknown scope in OutputFile: " + scope)
+	}
+
+	return &OutputFileMenu{
+		Name:     o.Name,
+		Template: o.Spec.Template,
+		Output:   o.Spec.Output,
+		Scope:    scope,
+		Attach:   o.Spec.Attach,
+	}, nil
+}
+
+func unmarshalGenerator(data []byte) (*GeneratorMenu, error) {
+	var g generatorConfig
+	err := yaml.Unmarshal(data, &g)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range g.Spec.Plugins {
+		if p == "" {
+			return nil, errors.New("plugin name in Generator is empty")
+		}
+	}
+
+	return &GeneratorMenu{TemplateDir: g.Spec.TemplateDir, Plugins: g.Spec.Plugins}, nil
+}
+
 // ReadYAML read placemat-menu resource files
 func ReadYAML(r *bufio.Reader) (*Menu, error) {
 	var m Menu
@@ -277,6 +794,24 @@ func ReadYAML(r *bufio.Reader) (*Menu, error) {
 				return nil, err
 			}
 			m.Nodes = append(m.Nodes, r)
+		case "ExternalPeer":
+			r, err := unmarshalExternalPeer(data)
+			if err != nil {
+				return nil, err
+			}
+			m.ExternalPeers = append(m.ExternalPeers, r)
+		case "OutputFile":
+			r, err := unmarshalOutputFile(data)
+			if err != nil {
+				return nil, err
+			}
+			m.OutputFiles = append(m.OutputFiles, r)
+		case "Generator":
+			r, err := unmarshalGenerator(data)
+			if err != nil {
+				return nil, err
+			}
+			m.Generator = r
 		default:
 			return nil, errors.New("unknown resource: " + c.Kind)
 		}
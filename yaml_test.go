@@ -17,6 +17,18 @@ func mustParseCIDR(s string) *net.IPNet {
 func testUnmarshalNetwork(t *testing.T) {
 	t.Parallel()
 
+	defaultPolicies := RoutingPolicyMenu{
+		Core:  defaultRoutingPolicy,
+		Spine: defaultRoutingPolicy,
+		ToR:   defaultRoutingPolicy,
+		Node:  defaultRoutingPolicy,
+	}
+	defaultBackends := RouterBackendsMenu{
+		Core:  "bird",
+		Spine: "bird",
+		ToR:   "bird",
+	}
+
 	cases := []struct {
 		source   string
 		expected NetworkMenu
@@ -43,7 +55,11 @@ spec:
 				NodeBase:       net.ParseIP("10.69.0.0").To4(),
 				NodeRangeSize:  6,
 				NodeRangeMask:  26,
+				NodeIPPerNode:  3,
 				BMC:            mustParseCIDR("10.72.16.0/20"),
+				BMCBase:        net.ParseIP("10.72.17.0").To4(),
+				BMCRangeSize:   5,
+				BMCRangeMask:   20,
 				ASNBase:        64600,
 				Internet:       mustParseCIDR("10.0.0.0/24"),
 				CoreSpine:      mustParseCIDR("10.0.2.0/24"),
@@ -54,6 +70,124 @@ spec:
 				LoadBalancer:   mustParseCIDR("10.72.32.0/20"),
 				Ingress:        mustParseCIDR("10.72.48.64/26"),
 				Global:         mustParseCIDR("172.17.0.0/24"),
+				RoutingPolicy:  defaultPolicies,
+				RouterBackends: defaultBackends,
+			},
+		},
+		{
+			source: `
+kind: Network
+spec:
+  ipam-config: example_ipam.json
+  asn-base: 64600
+  internet: 10.0.0.0/24
+  core-spine: 10.0.2.0/24
+  core-external: 10.0.3.0/24
+  core-operation: 10.0.4.0/24
+  spine-tor: 10.0.1.0
+  exposed:
+    loadbalancer: 10.72.32.0/20
+    bastion: 10.72.48.0/26
+    ingress: 10.72.48.64/26
+    global: 172.17.0.0/24
+  storage:
+    network: 10.70.0.0/20
+    range-size: 6
+    backbone: 10.70.255.0/24
+    cs: true
+`,
+			expected: NetworkMenu{
+				IPAMConfigFile:   "example_ipam.json",
+				NodeBase:         net.ParseIP("10.69.0.0").To4(),
+				NodeRangeSize:    6,
+				NodeRangeMask:    26,
+				NodeIPPerNode:    3,
+				BMC:              mustParseCIDR("10.72.16.0/20"),
+				BMCBase:          net.ParseIP("10.72.17.0").To4(),
+				BMCRangeSize:     5,
+				BMCRangeMask:     20,
+				ASNBase:          64600,
+				Internet:         mustParseCIDR("10.0.0.0/24"),
+				CoreSpine:        mustParseCIDR("10.0.2.0/24"),
+				CoreExternal:     mustParseCIDR("10.0.3.0/24"),
+				CoreOperation:    mustParseCIDR("10.0.4.0/24"),
+				SpineTor:         net.ParseIP("10.0.1.0"),
+				Bastion:          mustParseCIDR("10.72.48.0/26"),
+				LoadBalancer:     mustParseCIDR("10.72.32.0/20"),
+				Ingress:          mustParseCIDR("10.72.48.64/26"),
+				Global:           mustParseCIDR("172.17.0.0/24"),
+				Storage:          mustParseCIDR("10.70.0.0/20"),
+				StorageRangeSize: 6,
+				StorageBackbone:  mustParseCIDR("10.70.255.0/24"),
+				StorageCS:        true,
+				RoutingPolicy:    defaultPolicies,
+				RouterBackends:   defaultBackends,
+			},
+		},
+		{
+			source: `
+kind: Network
+spec:
+  ipam-config: example_ipam.json
+  asn-base: 64600
+  internet: 10.0.0.0/24
+  core-spine: 10.0.2.0/24
+  core-external: 10.0.3.0/24
+  core-operation: 10.0.4.0/24
+  spine-tor: 10.0.1.0
+  exposed:
+    loadbalancer: 10.72.32.0/20
+    bastion: 10.72.48.0/26
+    ingress: 10.72.48.64/26
+    global: 172.17.0.0/24
+  routing-policy:
+    default:
+      bfd:
+        interval: 300
+        multiplier: 3
+      bgp:
+        hold-time: 9
+        keepalive-time: 3
+    core:
+      bgp:
+        ecmp-limit: 4
+    node:
+      bfd:
+        interval: 1000
+      bgp:
+        graceful-restart: true
+        add-paths: true
+  router-backends:
+    spine: frr
+    tor: frr
+`,
+			expected: NetworkMenu{
+				IPAMConfigFile: "example_ipam.json",
+				NodeBase:       net.ParseIP("10.69.0.0").To4(),
+				NodeRangeSize:  6,
+				NodeRangeMask:  26,
+				NodeIPPerNode:  3,
+				BMC:            mustParseCIDR("10.72.16.0/20"),
+				BMCBase:        net.ParseIP("10.72.17.0").To4(),
+				BMCRangeSize:   5,
+				BMCRangeMask:   20,
+				ASNBase:        64600,
+				Internet:       mustParseCIDR("10.0.0.0/24"),
+				CoreSpine:      mustParseCIDR("10.0.2.0/24"),
+				CoreExternal:   mustParseCIDR("10.0.3.0/24"),
+				CoreOperation:  mustParseCIDR("10.0.4.0/24"),
+				SpineTor:       net.ParseIP("10.0.1.0"),
+				Bastion:        mustParseCIDR("10.72.48.0/26"),
+				LoadBalancer:   mustParseCIDR("10.72.32.0/20"),
+				Ingress:        mustParseCIDR("10.72.48.64/26"),
+				Global:         mustParseCIDR("172.17.0.0/24"),
+				RoutingPolicy: RoutingPolicyMenu{
+					Core:  RoutingPolicy{BFDInterval: 300, BFDMultiplier: 3, HoldTime: 9, KeepaliveTime: 3, ECMPLimit: 4},
+					Spine: RoutingPolicy{BFDInterval: 300, BFDMultiplier: 3, HoldTime: 9, KeepaliveTime: 3},
+					ToR:   RoutingPolicy{BFDInterval: 300, BFDMultiplier: 3, HoldTime: 9, KeepaliveTime: 3},
+					Node:  RoutingPolicy{BFDInterval: 1000, BFDMultiplier: 3, HoldTime: 9, KeepaliveTime: 3, GracefulRestart: true, AddPaths: true},
+				},
+				RouterBackends: RouterBackendsMenu{Core: "bird", Spine: "frr", ToR: "frr"},
 			},
 		},
 	}
@@ -94,6 +228,47 @@ spec:
     loadbalancer: 10.72.32.0/20
     bastion: 10.72.48.0/26
     ingress: 10.72.48.64/26
+`,
+		`
+# No seed nor secrets-file @ bgp-auth
+kind: Network
+spec:
+  ipam-config: example_ipam.json
+  asn-base: 64600
+  internet: 10.0.0.0/24
+  spine-tor: 10.0.1.0
+  core-spine: 10.0.2.0/31
+  core-external: 10.0.3.0/24
+  core-operation: 10.0.4.0/24
+  exposed:
+    loadbalancer: 10.72.32.0/20
+    bastion: 10.72.48.0/26
+    ingress: 10.72.48.64/26
+    global: 172.17.0.0/24
+  bgp-auth:
+    nodes: true
+`,
+		`
+# keepalive-time is not less than hold-time @ routing-policy
+kind: Network
+spec:
+  ipam-config: example_ipam.json
+  asn-base: 64600
+  internet: 10.0.0.0/24
+  spine-tor: 10.0.1.0
+  core-spine: 10.0.2.0/31
+  core-external: 10.0.3.0/24
+  core-operation: 10.0.4.0/24
+  exposed:
+    loadbalancer: 10.72.32.0/20
+    bastion: 10.72.48.0/26
+    ingress: 10.72.48.64/26
+    global: 172.17.0.0/24
+  routing-policy:
+    spine:
+      bgp:
+        hold-time: 3
+        keepalive-time: 3
 `,
 	}
 
@@ -130,9 +305,45 @@ spec:
 				ClusterID: "dev0",
 				Spine:     3,
 				Rack: []RackMenu{
-					{CS: 3, SS: 0},
-					{CS: 2, SS: 2},
-					{CS: 0, SS: 3},
+					{CS: 3, SS: 0, Boot: 1},
+					{CS: 2, SS: 2, Boot: 1},
+					{CS: 0, SS: 3, Boot: 1},
+				},
+			},
+		},
+		{
+			source: `
+kind: Inventory
+spec:
+  cluster-id: dev0
+  spine: 3
+  rack:
+    - cs: 3
+      ss: 0
+      boot: 0
+      spines: [1, 2]
+    - cs: 2
+      ss: 2
+      boot: 2
+      down-links:
+        - spine: 3
+          tor: 2
+    - cs: 1
+      ss: 0
+      tor-spines:
+        1: [1]
+        2: [2, 3]
+      down-links:
+        - spine: 3
+          tor: 2
+`,
+			expected: InventoryMenu{
+				ClusterID: "dev0",
+				Spine:     3,
+				Rack: []RackMenu{
+					{CS: 3, SS: 0, Boot: 0, Spines: []int{1, 2}},
+					{CS: 2, SS: 2, Boot: 2, DownLinks: []LinkMenu{{Spine: 3, ToR: 2}}},
+					{CS: 1, SS: 0, Boot: 1, ToRSpines: map[int][]int{1: {1}, 2: {2, 3}}, DownLinks: []LinkMenu{{Spine: 3, ToR: 2}}},
 				},
 			},
 		},
@@ -156,6 +367,83 @@ spec:
   rack:
     - cs: 3
       ss: 0
+`,
+		`
+# No such spine
+kind: Inventory
+spec:
+  cluster-id: dev0
+  spine: 2
+  rack:
+    - cs: 3
+      ss: 0
+      spines: [1, 3]
+`,
+		`
+# No such ToR
+kind: Inventory
+spec:
+  cluster-id: dev0
+  spine: 2
+  rack:
+    - cs: 3
+      ss: 0
+      down-links:
+        - spine: 1
+          tor: 3
+`,
+		`
+# No such ToR in tor-spines
+kind: Inventory
+spec:
+  cluster-id: dev0
+  spine: 2
+  rack:
+    - cs: 3
+      ss: 0
+      tor-spines:
+        3: [1]
+`,
+		`
+# No such spine in tor-spines
+kind: Inventory
+spec:
+  cluster-id: dev0
+  spine: 2
+  rack:
+    - cs: 3
+      ss: 0
+      tor-spines:
+        1: [3]
+`,
+		`
+# Down link not wired
+kind: Inventory
+spec:
+  cluster-id: dev0
+  spine: 2
+  rack:
+    - cs: 3
+      ss: 0
+      spines: [2]
+      down-links:
+        - spine: 1
+          tor: 1
+`,
+		`
+# Down link not wired to the ToR
+kind: Inventory
+spec:
+  cluster-id: dev0
+  spine: 2
+  rack:
+    - cs: 3
+      ss: 0
+      tor-spines:
+        2: [2]
+      down-links:
+        - spine: 1
+          tor: 2
 `,
 	}
 
@@ -216,6 +504,49 @@ spec:
 				Memory: "1G",
 			},
 		},
+		{
+			source: `
+kind: Node
+type: cs
+spec:
+  cpu: 2
+  memory: 4G
+  cloud-init:
+    users:
+    - name: cybozu
+      groups: [sudo]
+      lock-passwd: false
+      ssh-authorized-keys:
+      - ssh-ed25519 AAAA cybozu
+    packages: [chrony]
+    write-files:
+    - path: /etc/motd
+      content: hello
+      permissions: 0644
+    runcmd:
+    - systemctl restart chrony
+`,
+			expected: NodeMenu{
+				Type:   CSNode,
+				CPU:    2,
+				Memory: "4G",
+				CloudInit: &CloudInitMenu{
+					Users: []CloudInitUser{
+						{
+							Name:              "cybozu",
+							Groups:            []string{"sudo"},
+							LockPasswd:        new(bool),
+							SSHAuthorizedKeys: []string{"ssh-ed25519 AAAA cybozu"},
+						},
+					},
+					Packages: []string{"chrony"},
+					WriteFiles: []CloudInitFile{
+						{Path: "/etc/motd", Content: "hello", Permissions: "0644"},
+					},
+					RunCmd: []string{"systemctl restart chrony"},
+				},
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -243,6 +574,71 @@ type: cs
 spec:
   cpu: 0
   memory: 2G
+`,
+		`
+# Both cloud-init and Ignition
+kind: Node
+type: cs
+spec:
+  cpu: 2
+  memory: 2G
+  cloud-init-template: seed.yml.template
+  ignition-template: ignition.yml.template
+`,
+		`
+# Both cloud-init section and Ignition
+kind: Node
+type: cs
+spec:
+  cpu: 2
+  memory: 2G
+  cloud-init:
+    packages: [chrony]
+  ignition-template: ignition.yml.template
+`,
+		`
+# vendor-data without seed
+kind: Node
+type: cs
+spec:
+  cpu: 2
+  memory: 2G
+  vendor-data-template: vendor-data.yml.template
+`,
+		`
+# Duplicate users
+kind: Node
+type: cs
+spec:
+  cpu: 2
+  memory: 2G
+  cloud-init:
+    users:
+    - name: cybozu
+    - name: cybozu
+`,
+		`
+# Relative path
+kind: Node
+type: cs
+spec:
+  cpu: 2
+  memory: 2G
+  cloud-init:
+    write-files:
+    - path: etc/motd
+`,
+		`
+# Invalid permissions
+kind: Node
+type: cs
+spec:
+  cpu: 2
+  memory: 2G
+  cloud-init:
+    write-files:
+    - path: /etc/motd
+      permissions: "0999"
 `,
 	}
 
@@ -254,8 +650,175 @@ spec:
 	}
 }
 
+func testUnmarshalExternalPeer(t *testing.T) {
+	t.Parallel()
+
+	source := `
+kind: ExternalPeer
+name: isp1
+spec:
+  asn: 65001
+  link: 10.0.5.0/30
+  prefixes:
+    - 203.0.113.0/24
+`
+	expected := ExternalPeerMenu{
+		Name:     "isp1",
+		ASN:      65001,
+		Link:     mustParseCIDR("10.0.5.0/30"),
+		Prefixes: []*net.IPNet{mustParseCIDR("203.0.113.0/24")},
+	}
+
+	actual, err := unmarshalExternalPeer([]byte(source))
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(*actual, expected) {
+		t.Errorf("%v != %v", *actual, expected)
+	}
+
+	errorSources := []string{
+		`
+# No name
+kind: ExternalPeer
+spec:
+  asn: 65001
+  link: 10.0.5.0/30
+`,
+		`
+# No ASN
+kind: ExternalPeer
+name: isp1
+spec:
+  link: 10.0.5.0/30
+`,
+		`
+# Too small link
+kind: ExternalPeer
+name: isp1
+spec:
+  asn: 65001
+  link: 10.0.5.0/31
+`,
+	}
+
+	for _, s := range errorSources {
+		_, err := unmarshalExternalPeer([]byte(s))
+		if err == nil {
+			t.Error("err == nil", s)
+		}
+	}
+}
+
+func testUnmarshalOutputFile(t *testing.T) {
+	t.Parallel()
+
+	source := `
+kind: OutputFile
+name: notes
+spec:
+  template: notes.template
+  output: "notes/{{.Name}}"
+  scope: tor
+  attach: true
+`
+	expected := OutputFileMenu{
+		Name:     "notes",
+		Template: "notes.template",
+		Output:   "notes/{{.Name}}",
+		Scope:    "tor",
+		Attach:   true,
+	}
+
+	actual, err := unmarshalOutputFile([]byte(source))
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(*actual, expected) {
+		t.Errorf("%v != %v", *actual, expected)
+	}
+
+	actual, err = unmarshalOutputFile([]byte(`
+kind: OutputFile
+name: hosts
+spec:
+  template: hosts.template
+  output: hosts
+`))
+	if err != nil {
+		t.Error(err)
+	} else if actual.Scope != "cluster" {
+		t.Error("default scope must be cluster")
+	}
+
+	errorSources := []string{
+		`
+# No name
+kind: OutputFile
+spec:
+  template: hosts.template
+  output: hosts
+`,
+		`
+# No output
+kind: OutputFile
+name: hosts
+spec:
+  template: hosts.template
+`,
+		`
+# Unknown scope
+kind: OutputFile
+name: hosts
+spec:
+  template: hosts.template
+  output: hosts
+  scope: pod
+`,
+		`
+# Attach for rack
+kind: OutputFile
+name: hosts
+spec:
+  template: hosts.template
+  output: "{{.Name}}"
+  scope: rack
+  attach: true
+`,
+	}
+
+	for _, s := range errorSources {
+		_, err := unmarshalOutputFile([]byte(s))
+		if err == nil {
+			t.Error("err == nil", s)
+		}
+	}
+}
+
+func testUnmarshalGenerator(t *testing.T) {
+	t.Parallel()
+
+	source := `
+kind: Generator
+spec:
+  template-dir: my-templates
+  plugins:
+    - hosts
+    - ./bin/notes
+`
+	expected := GeneratorMenu{TemplateDir: "my-templates", Plugins: []string{"hosts", "./bin/notes"}}
+
+	actual, err := unmarshalGenerator([]byte(source))
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(*actual, expected) {
+		t.Errorf("%v != %v", *actual, expected)
+	}
+}
+
 func TestYAML(t *testing.T) {
 	t.Run("network", testUnmarshalNetwork)
 	t.Run("inventory", testUnmarshalInventory)
 	t.Run("node", testUnmarshalNode)
+	t.Run("external-peer", testUnmarshalExternalPeer)
+	t.Run("output-file", testUnmarshalOutputFile)
+	t.Run("generator", testUnmarshalGenerator)
 }